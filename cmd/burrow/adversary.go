@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	adversarial    = flag.String("adversarial", "", "comma-separated attacks to make on each server as a bad peer, or \"all\": "+strings.Join(attackNames(), ", "))
+	floodLinks     = flag.Int("flood-links", 1000, "number of links to send to each server at once (with -adversarial=flood)")
+	hugeTitleSize  = flag.Int("huge-title-size", 10<<20, "size in bytes of the title to send (with -adversarial=huge-title)")
+	slowlorisConns = flag.Int("slowloris-conns", 100, "number of connections to keep open to each server by sending requests slowly (with -adversarial=slowloris)")
+	slowlorisTime  = flag.Duration("slowloris-time", 5*time.Second, "how long to keep slowloris connections open")
+	slowThreshold  = flag.Duration("slow-threshold", 500*time.Millisecond, "how long a server may take to respond to GET /healthz during an attack before it is reported as slowed")
+)
+
+// adversaryPath is the path prefix of the links that burrow sends as a bad
+// peer, which aren't counted in the stats when servers forward them.
+const adversaryPath = "/adversary/"
+
+// An attack sends bad requests to the server at host, and reports which of
+// them the server accepted (that it should have rejected).
+type attack struct {
+	name string
+	run  func(a *attackRun) error
+}
+
+var attacks = []attack{
+	{"malformed-json", attackMalformedJSON},
+	{"huge-title", attackHugeTitle},
+	{"xss", attackXSS},
+	{"invalid-urls", attackInvalidURLs},
+	{"slowloris", attackSlowloris},
+	{"flood", attackFlood},
+}
+
+func attackNames() []string {
+	var names []string
+	for _, a := range attacks {
+		names = append(names, a.name)
+	}
+	return names
+}
+
+// selectedAttacks returns the attacks named in -adversarial.
+func selectedAttacks() ([]attack, error) {
+	if *adversarial == "" {
+		return nil, nil
+	}
+	if *adversarial == "all" {
+		return attacks, nil
+	}
+	var as []attack
+	for _, name := range strings.Split(*adversarial, ",") {
+		found := false
+		for _, a := range attacks {
+			if a.name == name {
+				as, found = append(as, a), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown attack %q (want %s)", name, strings.Join(attackNames(), ", "))
+		}
+	}
+	return as, nil
+}
+
+// attackResult is the outcome of one attack on one server.
+type attackResult struct {
+	Server string
+	Attack string
+
+	// Statuses counts the server's responses to the attack's requests by
+	// HTTP status code (or "error" if a request failed).
+	Statuses map[string]int `json:",omitempty"`
+
+	// Accepted is whether the server accepted bad data that it should have
+	// rejected (or, for slowloris, kept slow connections open), and Detail
+	// says what.
+	Accepted bool
+	Detail   string `json:",omitempty"`
+
+	// Crashed is whether the server stopped responding after the attack,
+	// and Slowed whether it took longer than -slow-threshold to respond to
+	// GET /healthz during it. Latency is the longest it took.
+	Crashed bool
+	Slowed  bool
+	Latency duration
+
+	Error string `json:",omitempty"` // if the attack couldn't be made
+}
+
+// attackRun is an attack in progress on one server.
+type attackRun struct {
+	host string
+	res  *attackResult
+
+	mu sync.Mutex
+}
+
+// post POSTs body to path on the server and counts the response status. It
+// reports whether the server accepted it (with a 2xx status).
+func (a *attackRun) post(path, contentType string, body io.Reader) bool {
+	status := "error"
+	resp, err := attackClient.Post(fmt.Sprintf("http://%s%s", a.host, path), contentType, body)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.res.Statuses[status]++
+	return err == nil && resp.StatusCode/100 == 2
+}
+
+// postLink POSTs the JSON-encoded link l to /links.
+func (a *attackRun) postLink(l any) bool {
+	body, _ := json.Marshal(l)
+	return a.post("/links", "application/json", bytes.NewReader(body))
+}
+
+// accepted records that the server accepted bad data.
+func (a *attackRun) accepted(format string, args ...any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.res.Accepted = true
+	if a.res.Detail != "" {
+		a.res.Detail += "; "
+	}
+	a.res.Detail += fmt.Sprintf(format, args...)
+}
+
+// attackClient is the HTTP client used to attack servers. It times out so
+// that a server that hangs doesn't stall burrow.
+var attackClient = &http.Client{Timeout: 10 * time.Second}
+
+// adversaryURL returns a URL on the fake title server for a link that burrow
+// sends as a bad peer.
+func adversaryURL(host, name string, i int) string {
+	return fmt.Sprintf("http://%s%s%s/%s?i=%d", *httpAddr, adversaryPath, strings.Replace(host, ":", "-", -1), name, i)
+}
+
+func attackMalformedJSON(a *attackRun) error {
+	for _, body := range []string{`{"URL":`, `not json`, `{"URL": 42}`, `[1, 2`, "\x00\xff\xfe", `{"URL": "` + adversaryURL(a.host, "malformed", 0) + `", "Title": {}}`} {
+		if a.post("/links", "application/json", strings.NewReader(body)) {
+			a.accepted("accepted malformed JSON %q", body)
+		}
+	}
+	return nil
+}
+
+func attackHugeTitle(a *attackRun) error {
+	l := link{URL: adversaryURL(a.host, "huge-title", 0), Title: strings.Repeat("A", *hugeTitleSize)}
+	if a.postLink(l) {
+		a.accepted("accepted a %d-byte title", len(l.Title))
+	}
+	return nil
+}
+
+// xssPayload is a title that a server must escape when it shows it.
+const xssPayload = `<script>alert("burrow")</script>`
+
+func attackXSS(a *attackRun) error {
+	a.postLink(link{URL: adversaryURL(a.host, "xss", 0), Title: xssPayload})
+	// The last URL is valid (so may be accepted), but must be escaped too.
+	for _, u := range []string{`javascript:alert("burrow")`, `data:text/html,` + xssPayload, adversaryURL(a.host, "xss", 1) + `&q="><script>alert("burrow")</script>`} {
+		if a.postLink(link{URL: u, Title: "xss"}) && !strings.HasPrefix(u, "http:") {
+			a.accepted("accepted URL %q", u)
+		}
+	}
+
+	// Titles (and URLs) may contain HTML, but the homepage must escape it.
+	resp, err := attackClient.Get(fmt.Sprintf("http://%s/", a.host))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(body, []byte(xssPayload)) {
+		a.accepted("showed an unescaped <script> on the homepage")
+	}
+	return nil
+}
+
+func attackInvalidURLs(a *attackRun) error {
+	for _, u := range []string{"", "not a url", "ftp://example.com/", "http://", "//example.com/", "http://exa mple.com/", "http://example.com/\x00", "/relative/path"} {
+		if a.postLink(link{URL: u, Title: "invalid"}) {
+			a.accepted("accepted URL %q", u)
+		}
+	}
+	return nil
+}
+
+// attackSlowloris opens -slowloris-conns connections to the server and
+// sends a request on each one header byte at a time, to see whether the
+// server times them out or lets them tie it up.
+func attackSlowloris(a *attackRun) error {
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < *slowlorisConns; i++ {
+		c, err := net.DialTimeout("tcp", a.host, 5*time.Second)
+		if err != nil {
+			if len(conns) == 0 {
+				return err
+			}
+			break // the server may limit connections, which is fine
+		}
+		conns = append(conns, c)
+		fmt.Fprintf(c, "POST /links HTTP/1.1\r\nHost: %s\r\nContent-Type: application/json\r\n", a.host)
+	}
+
+	deadline := time.Now().Add(*slowlorisTime)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		for _, c := range conns {
+			c.SetWriteDeadline(time.Now().Add(time.Second))
+			c.Write([]byte("X"))
+		}
+	}
+
+	// Connections that the server timed out fail to write (or read EOF).
+	var open int
+	for _, c := range conns {
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := c.Write([]byte("-Burrow: 1\r\n")); err != nil {
+			continue
+		}
+		c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err != nil && !isTimeout(err) {
+			continue
+		}
+		open++
+	}
+	a.res.Statuses = map[string]int{"open": open, "closed": len(conns) - open}
+	if open > 0 {
+		a.accepted("kept %d of %d slow connections open for %s", open, len(conns), *slowlorisTime)
+	}
+	return nil
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// attackFlood sends -flood-links (valid) links to the server at once.
+func attackFlood(a *attackRun) error {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 100)
+	for i := 0; i < *floodLinks; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.postLink(link{URL: adversaryURL(a.host, "flood", i), Title: fmt.Sprintf("flood %d", i)})
+			<-sem
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// probe reports how long the server at host takes to respond to GET
+// /healthz (any response means it's up).
+func probe(host string) (time.Duration, error) {
+	t0 := time.Now()
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/healthz", host))
+	if err != nil {
+		return time.Since(t0), err
+	}
+	resp.Body.Close()
+	return time.Since(t0), nil
+}
+
+// runAttack makes the attack on the server at host while probing how long
+// it takes to respond, and then checks that it's still up.
+func runAttack(host string, at attack) *attackResult {
+	res := &attackResult{Server: host, Attack: at.name, Statuses: make(map[string]int)}
+	a := &attackRun{host: host, res: res}
+
+	done := make(chan struct{})
+	var worst time.Duration
+	probed := make(chan struct{})
+	go func() {
+		defer close(probed)
+		for {
+			if d, _ := probe(host); d > worst {
+				worst = d
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+	err := at.run(a)
+	close(done)
+	<-probed
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if len(res.Statuses) == 0 {
+		res.Statuses = nil
+	}
+
+	// Give a struggling server a few seconds to recover before declaring it
+	// crashed.
+	res.Crashed = true
+	for i := 0; i < 3; i++ {
+		d, err := probe(host)
+		worst = max(worst, d)
+		if err == nil {
+			res.Crashed = false
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	res.Latency = duration(worst)
+	res.Slowed = worst > *slowThreshold
+	return res
+}
+
+// attackServers makes each attack on each server (one attack at a time on
+// each server, and on all servers at once) and records the results in st.
+// Attacks on a server stop once it crashes.
+func attackServers(st *stats, as []attack) {
+	var wg sync.WaitGroup
+	for _, host := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, at := range as {
+				if *verbose {
+					log.Printf("Attacking %q with %s...", host, at.name)
+				}
+				res := runAttack(host, at)
+				st.addAttackResult(res)
+				if res.Crashed {
+					log.Printf("Server %q crashed after %s attack.", host, at.name)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Verdict summarizes an attack result.
+func (res *attackResult) Verdict() string {
+	var vs []string
+	if res.Crashed {
+		vs = append(vs, "crashed")
+	}
+	if res.Slowed {
+		vs = append(vs, "slowed")
+	}
+	if res.Accepted {
+		vs = append(vs, "accepted bad data")
+	}
+	if res.Error != "" {
+		vs = append(vs, "error: "+res.Error)
+	}
+	if len(vs) == 0 {
+		return "ok"
+	}
+	return strings.Join(vs, ", ")
+}
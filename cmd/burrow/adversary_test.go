@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSelectedAttacks tests parsing the attacks named in -adversarial.
+func TestSelectedAttacks(t *testing.T) {
+	defer func(v string) { *adversarial = v }(*adversarial)
+	for _, test := range []struct {
+		flag    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"all", attackNames(), false},
+		{"xss", []string{"xss"}, false},
+		{"flood,malformed-json", []string{"flood", "malformed-json"}, false},
+		{"xss,nope", nil, true},
+		{"xss,", nil, true},
+	} {
+		*adversarial = test.flag
+		as, err := selectedAttacks()
+		if (err != nil) != test.wantErr {
+			t.Errorf("-adversarial=%q: got error %v, want error %v", test.flag, err, test.wantErr)
+			continue
+		}
+		var names []string
+		for _, a := range as {
+			names = append(names, a.name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("-adversarial=%q: got attacks %q, want %q", test.flag, names, test.want)
+		}
+	}
+}
+
+// TestAttackResult_Verdict tests that verdicts list everything that went
+// wrong in an attack.
+func TestAttackResult_Verdict(t *testing.T) {
+	for _, test := range []struct {
+		res  attackResult
+		want string
+	}{
+		{attackResult{}, "ok"},
+		{attackResult{Accepted: true}, "accepted bad data"},
+		{attackResult{Crashed: true, Slowed: true}, "crashed, slowed"},
+		{attackResult{Slowed: true, Accepted: true, Error: "connection refused"}, "slowed, accepted bad data, error: connection refused"},
+	} {
+		if got := test.res.Verdict(); got != test.want {
+			t.Errorf("%+v: got verdict %q, want %q", test.res, got, test.want)
+		}
+	}
+}
@@ -5,21 +5,30 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
-	"net/url"
+	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+	"text/tabwriter"
 	"text/template"
 	"time"
 )
 
-var httpAddr = flag.String("http", "localhost:7002", "externally addressable host:port to host stats counter server")
-var peerAddr = flag.String("peer", "localhost:7001", "externally addressable peer listen host:port to use (when adding self as peer to servers)")
+var httpAddr = flag.String("http", "localhost:7002", "externally addressable host:port to host the fake title server and the dashboard (at /dashboard) on")
+var peerAddr = flag.String("peer", "localhost:7101", "externally addressable peer listen host:port to use (when adding self as peer to servers); each server is given its own peer port, counting up from this one")
 var serversStr = flag.String("servers", "", "comma-separated list of servers (ex: 'example.com:7000,foo.com:1234')")
 var numLinks = flag.Int("links", 10, "number of links to add per server")
+var runDuration = flag.Duration("duration", 5*time.Second, "how long to run for after adding links (0 to run until interrupted)")
+var interval = flag.Duration("interval", time.Second, "how often to record each server's stats (and refresh the dashboard)")
+var jsonFile = flag.String("json", "", "file to write the results to as JSON at the end of the run")
+var reportFormat = flag.String("report", "text", "format of the results printed at the end of the run: text (a leaderboard) or json")
 var verbose = flag.Bool("v", false, "show verbose output")
 
 var servers []string
@@ -33,72 +42,60 @@ func main() {
 	if *numLinks < 1 {
 		log.Fatal("Error: -links must be a positive number.")
 	}
+	if *interval <= 0 {
+		log.Fatal("Error: -interval must be positive.")
+	}
+	servers = strings.Split(*serversStr, ",")
+	peerAddrs, err := serverPeerAddrs(*peerAddr, len(servers))
+	if err != nil {
+		log.Fatalf("Error: bad -peer: %s", err)
+	}
+	as, err := selectedAttacks()
+	if err != nil {
+		log.Fatalf("Error: bad -adversarial: %s", err)
+	}
+	st := newStats(servers)
 
-	// Start a fake server.
-	stats := struct {
-		fetched map[string]int
-		readded map[string]int
-		mu      sync.Mutex
-	}{fetched: make(map[string]int), readded: make(map[string]int)}
+	// Start a fake server, which serves link titles and the dashboard.
 	fakeServerMux := http.NewServeMux()
 	fakeServerMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		defer st.fetch(r.URL)()
 		time.Sleep(time.Millisecond * time.Duration(rand.Intn(120)))
 		id := strings.TrimPrefix(r.URL.Path, "/")
-		stats.mu.Lock()
-		defer stats.mu.Unlock()
-		stats.fetched[id]++
 		fmt.Fprintf(w, "<title>fetched-%s</title>", template.HTMLEscapeString(id))
 	})
+	fakeServerMux.HandleFunc("/dashboard", serveDashboard(st))
+	fakeServerMux.HandleFunc("/dashboard.json", serveDashboardJSON(st))
 	go func() {
 		err := http.ListenAndServe(*httpAddr, fakeServerMux)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
-	fakePeerMux := http.NewServeMux()
-	fakePeerMux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
-		var link *link
-		err := json.NewDecoder(r.Body).Decode(&link)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
-			return
-		}
-		// Validate the URL.
-		if link.URL == "" {
-			http.Error(w, "no url", http.StatusBadRequest)
-			return
-		}
-		url, err := url.Parse(link.URL)
-		if err != nil {
-			http.Error(w, "bad url", http.StatusBadRequest)
-			return
-		}
-		stats.mu.Lock()
-		defer stats.mu.Unlock()
-		stats.readded[strings.TrimPrefix(url.Path, "/")]++
-	})
-	fakePeerMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
-	go func() {
-		err := http.ListenAndServe(*peerAddr, fakePeerMux)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
 
-	servers = strings.Split(*serversStr, ",")
+	// Start a fake peer for each server, so that burrow knows which server
+	// forwarded each link.
+	for i, s := range servers {
+		go func() {
+			err := http.ListenAndServe(peerAddrs[i], fakePeer(st, s))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
 
 	// Register servers as each other's peers.
-	for _, s := range servers {
+	for i, s := range servers {
 		// Make a list of all servers except for this one (to avoid self-loops).
 		allPeers := make([]string, len(servers))
-		for i, s2 := range servers {
+		for j, s2 := range servers {
 			if s == s2 {
 				// Don't add a server as its own peer. Instead, use this slot
 				// for the burrow server.
-				allPeers[i] = *peerAddr
+				allPeers[j] = peerAddrs[i]
 				continue
 			}
-			allPeers[i] = s2
+			allPeers[j] = s2
 		}
 		allPeersJSON, err := json.Marshal(allPeers)
 		if err != nil {
@@ -125,13 +122,14 @@ func main() {
 	if *verbose {
 		log.Printf("Adding %d links to each server...", *numLinks)
 	}
+	order := append([]string(nil), servers...)
 	for i := 0; i < *numLinks; i++ {
 		if i%2 == 0 {
-			sort.Strings(servers)
+			sort.Strings(order)
 		} else {
-			sort.Sort(sort.Reverse(sort.StringSlice(servers)))
+			sort.Sort(sort.Reverse(sort.StringSlice(order)))
 		}
-		for _, s := range servers {
+		for _, s := range order {
 			// Add a link title for about half of all links.
 			link := &link{
 				URL: fmt.Sprintf("http://%s/%s?i=%d", *httpAddr, strings.Replace(s, ":", "-", -1), i),
@@ -144,6 +142,7 @@ func main() {
 				log.Printf("Adding link %v to %q...", link, s)
 			}
 
+			st.added(s, link)
 			go func() {
 				err := addLink(s, link)
 				if err != nil {
@@ -158,22 +157,189 @@ func main() {
 	}
 
 	if *verbose {
-		log.Printf("Done.")
+		log.Printf("Done. See the dashboard at http://%s/dashboard.", *httpAddr)
+	}
+	attacked := make(chan struct{})
+	go func() {
+		attackServers(st, as)
+		close(attacked)
+	}()
+	run(st)
+	if len(as) > 0 {
+		log.Printf("Waiting for attacks to finish...")
+		<-attacked
+	}
+
+	res := st.results(servers)
+	switch *reportFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		writeLeaderboard(os.Stdout, res.Latest)
+		writeAttacks(os.Stdout, res.Attacks)
+	}
+	if *jsonFile != "" {
+		if err := writeJSON(*jsonFile, res); err != nil {
+			log.Fatalf("Error writing results: %s", err)
+		}
+	}
+}
+
+// serverPeerAddrs returns the address of the fake peer for each of n
+// servers, on consecutive ports starting at addr's.
+func serverPeerAddrs(addr string, n int) ([]string, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
 	}
-	time.Sleep(time.Millisecond * 5000)
-	stats.mu.Lock()
-	defer stats.mu.Unlock()
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return nil, fmt.Errorf("bad port %q", portStr)
+	}
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = net.JoinHostPort(host, strconv.Itoa(port+i))
+	}
+	return addrs, nil
+}
 
-	fmt.Println("Fetches:")
-	for s, n := range stats.fetched {
-		fmt.Printf("%s\t%d\n", s, n)
+// fakePeer returns the handler of the fake peer for server, which records
+// the links that server forwards with POST /links and POST /links/batch.
+// It doesn't support streams (so servers fall back to POST /links).
+func fakePeer(st *stats, server string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		var link *link
+		err := json.NewDecoder(r.Body).Decode(&link)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+			return
+		}
+		// Validate the URL.
+		if link == nil || link.URL == "" {
+			http.Error(w, "no url", http.StatusBadRequest)
+			return
+		}
+		st.receive(server, link)
+	})
+	mux.HandleFunc("/links/batch", func(w http.ResponseWriter, r *http.Request) {
+		// Batches are JSON arrays (or newline-delimited JSON, which this
+		// decodes as a sequence of values).
+		dec := json.NewDecoder(r.Body)
+		var links []*link
+		for dec.More() {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+				return
+			}
+			var ls []*link
+			if err := json.Unmarshal(v, &ls); err != nil {
+				var l *link
+				if err := json.Unmarshal(v, &l); err != nil {
+					http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+					return
+				}
+				ls = []*link{l}
+			}
+			links = append(links, ls...)
+		}
+		// Servers only count the batch as delivered if the response has a
+		// result for each link.
+		type itemResult struct{ URL, Status string }
+		res := struct {
+			Applied bool
+			Results []itemResult
+		}{Applied: true, Results: []itemResult{}}
+		for _, l := range links {
+			if l != nil && l.URL != "" {
+				st.receive(server, l)
+				res.Results = append(res.Results, itemResult{l.URL, "added"})
+			} else {
+				res.Results = append(res.Results, itemResult{Status: "invalid"})
+			}
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+	return mux
+}
+
+// run records stats every -interval until -duration has passed or burrow is
+// interrupted.
+func run(st *stats) {
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var done <-chan time.Time
+	if *runDuration > 0 {
+		done = time.After(*runDuration)
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case <-ticker.C:
+			st.record(servers)
+		case <-done:
+			return
+		case <-sigc:
+			return
+		}
+	}
+}
+
+// writeLeaderboard writes a table of each server's stats to w, from the
+// highest score to the lowest, followed by the checks that each failed.
+func writeLeaderboard(w io.Writer, snap *snapshot) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tServer\tScore\tGrade\tAdded\tFetches\tExtra fetches\tPeak fetches\tForwarded\tWrong titles\tDuplicate re-adds\tPropagation p50\tp90\tmax")
+	ranked := snap.leaderboard()
+	for i, ss := range ranked {
+		fmt.Fprintf(tw, "%d\t%s\t%.1f\t%s\t%d\t%d\t%d\t%d\t%d/%d\t%d\t%d\t%s\t%s\t%s\n",
+			i+1, ss.Host, ss.Score, ss.Grade, ss.Added, ss.Fetches, ss.ExtraFetches, ss.PeakFetches,
+			ss.Forwarded, snap.Links, ss.WrongTitles, ss.Duplicates,
+			ss.Propagation.P50, ss.Propagation.P90, ss.Propagation.Max)
+	}
+	tw.Flush()
+	for _, ss := range ranked {
+		for _, c := range ss.Checks {
+			if !c.Passed {
+				fmt.Fprintf(w, "%s failed %q: %s\n", ss.Host, c.Name, c.Detail)
+			}
+		}
+	}
+	if snap.Unknown > 0 {
+		fmt.Fprintf(w, "%d links forwarded that burrow didn't add\n", snap.Unknown)
+	}
+}
+
+// writeAttacks writes a table of the results of each attack on each server
+// to w.
+func writeAttacks(w io.Writer, results []*attackResult) {
+	if len(results) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Server\tAttack\tResult\tHealth check latency\tDetail")
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", res.Server, res.Attack, res.Verdict(), res.Latency, res.Detail)
 	}
-	fmt.Println()
+	tw.Flush()
+}
 
-	fmt.Println("Re-adds:")
-	for s, n := range stats.readded {
-		fmt.Printf("%s\t%d\n", s, n)
+// writeJSON writes res to the file at path.
+func writeJSON(path string, res *results) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(path, append(data, '\n'), 0666)
 }
 
 type link struct {
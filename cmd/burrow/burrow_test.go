@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFakePeer_Batch tests that the fake peer records the links in POST
+// /links/batch requests, whether they're JSON arrays or newline-delimited
+// JSON, and responds with a result for each.
+func TestFakePeer_Batch(t *testing.T) {
+	st := newStats([]string{"a"})
+	for _, path := range []string{"/1", "/2", "/3"} {
+		st.added("a", &link{URL: "http://burrow.example.com" + path, Title: "T"})
+	}
+	peer := httptest.NewServer(fakePeer(st, "a"))
+	defer peer.Close()
+
+	for _, test := range []struct {
+		name, body   string
+		wantStatuses []string
+	}{
+		{"array", `[{"URL":"http://burrow.example.com/1","Title":"T"},{"URL":"http://burrow.example.com/2","Title":"T"}]`, []string{"added", "added"}},
+		{"NDJSON", `{"URL":"http://burrow.example.com/2","Title":"T"}` + "\n" + `{"URL":"http://burrow.example.com/3","Title":"T"}` + "\n", []string{"added", "added"}},
+		{"null", `[{"URL":"http://burrow.example.com/3","Title":"T"},null]`, []string{"added", "invalid"}},
+		{"empty", `[]`, nil},
+	} {
+		resp, err := http.Post(peer.URL+"/links/batch", "application/json", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res struct {
+			Applied bool
+			Results []struct{ URL, Status string }
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil || resp.Header.Get("content-type") != "application/json" {
+			t.Fatalf("%s: got content-type %q, %v, want a JSON batch result", test.name, resp.Header.Get("content-type"), err)
+		}
+		var statuses []string
+		for _, r := range res.Results {
+			statuses = append(statuses, r.Status)
+		}
+		if !res.Applied || strings.Join(statuses, ",") != strings.Join(test.wantStatuses, ",") {
+			t.Errorf("%s: got applied %v with statuses %q, want applied with %q", test.name, res.Applied, statuses, test.wantStatuses)
+		}
+	}
+
+	resp, err := http.Post(peer.URL+"/links/batch", "application/json", strings.NewReader(`[{"URL":`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad JSON: got status %d, want 400", resp.StatusCode)
+	}
+
+	snap := st.snapshot([]string{"a"})
+	if ss := snap.Servers[0]; ss.Forwarded != 3 || ss.Duplicates != 2 {
+		t.Errorf("got %d links forwarded and %d duplicates, want 3 and 2", ss.Forwarded, ss.Duplicates)
+	}
+}
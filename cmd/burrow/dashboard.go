@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// results is what the dashboard shows, and what it (and -json) exports.
+type results struct {
+	Start    time.Time
+	Duration duration // of the run (0 if it runs until interrupted)
+	Servers  []string
+	Latest   *snapshot
+	History  []*snapshot
+
+	// Leaderboard holds the servers, from the highest latest score to the
+	// lowest.
+	Leaderboard []string
+
+	Attacks []*attackResult `json:",omitempty"`
+}
+
+func (st *stats) results(servers []string) *results {
+	latest := st.snapshot(servers)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	res := &results{
+		Start:    st.start,
+		Duration: duration(*runDuration),
+		Servers:  servers,
+		Latest:   latest,
+		History:  append([]*snapshot(nil), st.history...),
+		Attacks:  append([]*attackResult(nil), st.attacks...),
+	}
+	slices.SortStableFunc(res.Attacks, func(a, b *attackResult) int {
+		return slices.Index(servers, a.Server) - slices.Index(servers, b.Server)
+	})
+	for _, ss := range latest.leaderboard() {
+		res.Leaderboard = append(res.Leaderboard, ss.Host)
+	}
+	return res
+}
+
+// Ranked returns the servers' latest stats, from the highest score to the
+// lowest.
+func (r *results) Ranked() []*serverStats { return r.Latest.leaderboard() }
+
+// Grades returns each server's grade in each snapshot in the history.
+func (r *results) Grades() map[string][]string {
+	gs := make(map[string][]string)
+	for _, snap := range r.History {
+		for _, ss := range snap.Servers {
+			gs[ss.Host] = append(gs[ss.Host], ss.Grade)
+		}
+	}
+	return gs
+}
+
+// serveDashboard serves the dashboard page, which reloads itself every
+// -interval.
+func serveDashboard(st *stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := dashboardTemplate.Execute(w, struct {
+			*results
+			Refresh int
+		}{st.results(servers), max(int(interval.Seconds()), 1)})
+		if err != nil {
+			log.Printf("Error rendering dashboard: %s", err)
+		}
+	}
+}
+
+// serveDashboardJSON serves the results as JSON.
+func serveDashboardJSON(st *stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(st.results(servers))
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>burrow</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 0.25em 0.75em; border-bottom: 1px solid #ddd; text-align: right; }
+td:nth-child(2), td.grades, td.checks { text-align: left; }
+td.grades { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>burrow</h1>
+<p>{{.Latest.Links}} links added in {{.Latest.Elapsed}}{{if .Duration}} (of {{.Duration}}){{end}}.
+{{if .Latest.Unknown}}{{.Latest.Unknown}} links forwarded that burrow didn't add.{{end}}
+<a href="/dashboard.json">JSON</a></p>
+<table>
+<tr>
+<th>#</th><th>Server</th><th>Score</th><th>Grade</th><th>Grades over time</th>
+<th>Added</th><th>Untitled</th><th>Fetches</th><th>Extra fetches</th><th>Peak fetches</th>
+<th>Forwarded</th><th>Wrong titles</th><th>Duplicate re-adds</th>
+<th>Propagation p50</th><th>p90</th><th>max</th><th>Failed checks</th>
+</tr>
+{{$grades := .Grades}}
+{{range $i, $s := .Ranked}}
+<tr>
+<td>{{inc $i}}</td><td>{{.Host}}</td><td>{{.Score}}</td><td>{{.Grade}}</td>
+<td class="grades">{{range index $grades .Host}}{{.}}{{end}}</td>
+<td>{{.Added}}</td><td>{{.Untitled}}</td><td>{{.Fetches}}</td><td>{{.ExtraFetches}}</td><td>{{.PeakFetches}}</td>
+<td>{{.Forwarded}}</td><td>{{.WrongTitles}}</td><td>{{.Duplicates}}</td>
+<td>{{.Propagation.P50}}</td><td>{{.Propagation.P90}}</td><td>{{.Propagation.Max}}</td>
+<td class="checks">{{range .Checks}}{{if not .Passed}}{{.Name}}: {{.Detail}}<br>{{end}}{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{with .Attacks}}
+<h2>Attacks</h2>
+<table>
+<tr><th>Server</th><th>Attack</th><th>Result</th><th>Health check latency</th><th>Responses</th><th>Detail</th></tr>
+{{range .}}
+<tr>
+<td>{{.Server}}</td><td class="checks">{{.Attack}}</td><td class="checks">{{.Verdict}}</td><td>{{.Latency}}</td>
+<td class="checks">{{range $status, $n := .Statuses}}{{$status}}: {{$n}} {{end}}</td><td class="checks">{{.Detail}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
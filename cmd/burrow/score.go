@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var maxFetches = flag.Int("max-fetches", 10, "most title fetches that a server may make at once (the servers' rate limit)")
+
+// A check is the result of checking one aspect of a server's behavior.
+type check struct {
+	Name   string
+	Passed bool
+	Score  float64 // 0 to 1
+	Detail string  `json:",omitempty"` // why it failed
+}
+
+// score checks the server's behavior, given that n links were added in
+// all, and sets its Checks, Score and Grade. The score is the mean of the
+// checks' scores.
+func (ss *serverStats) score(n int) {
+	ratio := func(bad, total int) float64 {
+		if total == 0 {
+			return 1
+		}
+		return max(1-float64(bad)/float64(total), 0)
+	}
+	ss.Checks = []check{
+		{
+			Name:   "forwarded all links",
+			Passed: ss.Forwarded == n,
+			Score:  ratio(n-ss.Forwarded, n),
+			Detail: fmt.Sprintf("forwarded %d of %d links", ss.Forwarded, n),
+		},
+		{
+			Name:   "fetched each untitled link at most once",
+			Passed: ss.ExtraFetches == 0,
+			Score:  ratio(ss.ExtraFetches, ss.Added),
+			Detail: fmt.Sprintf("%d unneeded fetches of %d links", ss.ExtraFetches, ss.Added),
+		},
+		{
+			Name:   "forwarded links with correct titles",
+			Passed: ss.WrongTitles == 0,
+			Score:  ratio(ss.WrongTitles, ss.Forwarded),
+			Detail: fmt.Sprintf("%d of %d links forwarded with wrong titles", ss.WrongTitles, ss.Forwarded),
+		},
+		{
+			Name:   "sent no duplicates",
+			Passed: ss.Duplicates == 0,
+			Score:  ratio(ss.Duplicates, ss.Forwarded+ss.Duplicates),
+			Detail: fmt.Sprintf("%d duplicates of %d links forwarded", ss.Duplicates, ss.Forwarded),
+		},
+		{
+			Name:   "respected the fetch rate limit",
+			Passed: ss.PeakFetches <= *maxFetches,
+			Score:  ratio(ss.PeakFetches-*maxFetches, ss.PeakFetches),
+			Detail: fmt.Sprintf("%d fetches at once (limit %d)", ss.PeakFetches, *maxFetches),
+		},
+	}
+	var sum float64
+	for i := range ss.Checks {
+		c := &ss.Checks[i]
+		if c.Passed {
+			c.Score, c.Detail = 1, ""
+		}
+		sum += c.Score
+	}
+	ss.Score = math.Round(1000*sum/float64(len(ss.Checks))) / 10
+	switch {
+	case n == 0:
+		ss.Grade = "-"
+	case ss.Score >= 90:
+		ss.Grade = "A"
+	case ss.Score >= 80:
+		ss.Grade = "B"
+	case ss.Score >= 70:
+		ss.Grade = "C"
+	case ss.Score >= 60:
+		ss.Grade = "D"
+	default:
+		ss.Grade = "F"
+	}
+}
+
+// leaderboard returns the servers in snap, from the highest score to the
+// lowest.
+func (snap *snapshot) leaderboard() []*serverStats {
+	ranked := append([]*serverStats(nil), snap.Servers...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked
+}
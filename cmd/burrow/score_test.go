@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestServerStats_Score tests that servers are scored by the mean of their
+// checks' scores, and graded by their score.
+func TestServerStats_Score(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		ss         serverStats
+		n          int
+		wantScore  float64
+		wantGrade  string
+		wantFailed []string
+	}{
+		{"perfect", serverStats{Added: 10, Forwarded: 10, PeakFetches: 10}, 10, 100, "A", nil},
+		{"no links", serverStats{}, 0, 100, "-", nil},
+		{"forwarded half", serverStats{Added: 10, Forwarded: 5}, 10, 90, "A", []string{"forwarded all links"}},
+		{"forwarded none", serverStats{Added: 10}, 10, 80, "B", []string{"forwarded all links"}},
+		{"duplicates and wrong titles", serverStats{Added: 10, Forwarded: 10, Duplicates: 10, WrongTitles: 5}, 10, 80, "B",
+			[]string{"forwarded links with correct titles", "sent no duplicates"}},
+		{"over the rate limit", serverStats{Added: 10, PeakFetches: 20}, 10, 70, "C",
+			[]string{"forwarded all links", "respected the fetch rate limit"}},
+		{"extra fetches", serverStats{Added: 10, ExtraFetches: 10}, 10, 60, "D",
+			[]string{"forwarded all links", "fetched each untitled link at most once"}},
+		{"everything wrong", serverStats{Added: 10, ExtraFetches: 20, PeakFetches: 40}, 10, 45, "F",
+			[]string{"forwarded all links", "fetched each untitled link at most once", "respected the fetch rate limit"}},
+	} {
+		ss := test.ss
+		ss.score(test.n)
+		if ss.Score != test.wantScore || ss.Grade != test.wantGrade {
+			t.Errorf("%s: got score %v (%s), want %v (%s)", test.name, ss.Score, ss.Grade, test.wantScore, test.wantGrade)
+		}
+		var failed []string
+		for _, c := range ss.Checks {
+			if !c.Passed {
+				failed = append(failed, c.Name)
+				if c.Detail == "" {
+					t.Errorf("%s: failed check %q has no detail", test.name, c.Name)
+				}
+			} else if c.Score != 1 || c.Detail != "" {
+				t.Errorf("%s: got passed check %+v, want score 1 and no detail", test.name, c)
+			}
+		}
+		if !reflect.DeepEqual(failed, test.wantFailed) {
+			t.Errorf("%s: got failed checks %q, want %q", test.name, failed, test.wantFailed)
+		}
+	}
+}
+
+// TestSnapshot_Leaderboard tests that the leaderboard ranks servers by
+// score, keeping the order of servers with the same score.
+func TestSnapshot_Leaderboard(t *testing.T) {
+	snap := &snapshot{Servers: []*serverStats{{Host: "a", Score: 50}, {Host: "b", Score: 90}, {Host: "c", Score: 50}, {Host: "d", Score: 100}}}
+	var hosts []string
+	for _, ss := range snap.leaderboard() {
+		hosts = append(hosts, ss.Host)
+	}
+	if want := []string{"d", "b", "a", "c"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got leaderboard %q, want %q", hosts, want)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stats records what burrow observes: the links it adds, the title fetches
+// of those links, and the links that each server forwards to it as a peer.
+type stats struct {
+	mu    sync.Mutex
+	start time.Time
+
+	// links holds the links that burrow added, keyed by linkKey.
+	links map[string]*addedLink
+
+	// fetches holds the number of times each link (keyed by linkKey) was
+	// fetched.
+	fetches map[string]int
+
+	// fetching holds the number of title fetches in progress of the links
+	// added to each server, and peakFetching the most there have been at
+	// once.
+	fetching, peakFetching map[string]int
+
+	// received holds the links (keyed by linkKey) that each server (keyed by
+	// host:port) forwarded to burrow.
+	received map[string]map[string]*receipt
+
+	// unknown is the number of links forwarded to burrow that it didn't add.
+	unknown int
+
+	// history holds a snapshot taken every -interval.
+	history []*snapshot
+
+	// attacks holds the results of attacks made with -adversarial.
+	attacks []*attackResult
+}
+
+// addedLink is a link that burrow added to a server.
+type addedLink struct {
+	server string
+	titled bool
+	title  string // that servers should forward it with
+	added  time.Time
+}
+
+// receipt records how many times a server forwarded a link to burrow, when
+// it first did, and whether it ever did with the wrong title.
+type receipt struct {
+	count      int
+	first      time.Time
+	wrongTitle bool
+}
+
+func newStats(servers []string) *stats {
+	st := &stats{
+		start:    time.Now(),
+		links:    make(map[string]*addedLink),
+		fetches:  make(map[string]int),
+		received: make(map[string]map[string]*receipt),
+
+		fetching:     make(map[string]int),
+		peakFetching: make(map[string]int),
+	}
+	for _, s := range servers {
+		st.received[s] = make(map[string]*receipt)
+	}
+	return st
+}
+
+// linkKey identifies a link that burrow added by its URL's path and query,
+// which are unchanged however a server normalizes the rest of the URL.
+func linkKey(u *url.URL) string { return u.RequestURI() }
+
+// added records that burrow added l (whose URL it generated) to server.
+func (st *stats) added(server string, l *link) {
+	u, _ := url.Parse(l.URL)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	title := l.Title
+	if title == "" {
+		title = fetchedTitle(u)
+	}
+	st.links[linkKey(u)] = &addedLink{server: server, titled: l.Title != "", title: title, added: time.Now()}
+}
+
+// fetchedTitle returns the title that the fake title server serves for the
+// link whose URL is u.
+func fetchedTitle(u *url.URL) string {
+	return "fetched-" + strings.TrimPrefix(u.Path, "/")
+}
+
+// fetch records the start of a title fetch of the link whose URL is u. The
+// returned func records its end.
+func (st *stats) fetch(u *url.URL) (done func()) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.fetches[linkKey(u)]++
+	l := st.links[linkKey(u)]
+	if l == nil {
+		return func() {}
+	}
+	st.fetching[l.server]++
+	st.peakFetching[l.server] = max(st.peakFetching[l.server], st.fetching[l.server])
+	return func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.fetching[l.server]--
+	}
+}
+
+// receive records that server forwarded l to burrow.
+func (st *stats) receive(server string, l *link) {
+	u, err := url.Parse(l.URL)
+	if err == nil && strings.HasPrefix(u.Path, adversaryPath) {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err != nil || st.links[linkKey(u)] == nil {
+		st.unknown++
+		return
+	}
+	added := st.links[linkKey(u)]
+	r := st.received[server][linkKey(u)]
+	if r == nil {
+		r = &receipt{first: time.Now()}
+		st.received[server][linkKey(u)] = r
+	}
+	r.count++
+	if l.Title != added.title {
+		r.wrongTitle = true
+		if *verbose {
+			log.Printf("%s forwarded link %s with title %q, want %q.", server, l.URL, l.Title, added.title)
+		}
+	}
+}
+
+// addAttackResult records the result of an attack made with -adversarial.
+func (st *stats) addAttackResult(res *attackResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.attacks = append(st.attacks, res)
+}
+
+// A snapshot is the state of each server at one point during the run.
+type snapshot struct {
+	Elapsed duration
+	Links   int // links added so far
+	Unknown int // links forwarded to burrow that it didn't add
+	Servers []*serverStats
+}
+
+// serverStats is the state of a server, as burrow observes it.
+type serverStats struct {
+	Host string
+
+	// Added is the number of links burrow added to the server, of which
+	// Untitled needed their titles fetched. Fetches is the number of title
+	// fetches of those links, and ExtraFetches is how many of them were
+	// unneeded (of a titled link, or of a link that was already fetched).
+	Added, Untitled       int
+	Fetches, ExtraFetches int
+
+	// PeakFetches is the most title fetches of those links that were in
+	// progress at once.
+	PeakFetches int
+
+	// Forwarded is the number of distinct links (of all that burrow added
+	// to any server) that the server forwarded to burrow, and Duplicates is
+	// how many times it forwarded a link again. WrongTitles is the number of
+	// links it forwarded with a title other than the one burrow added or
+	// served.
+	Forwarded, Duplicates, WrongTitles int
+
+	// Propagation is the time from when burrow added each forwarded link
+	// (to any server) until this server forwarded it.
+	Propagation latencySummary
+
+	// Checks holds the results of checking the server's behavior, from
+	// which Score (0 to 100) and Grade are computed (see score).
+	Checks []check
+	Score  float64
+	Grade  string
+}
+
+// snapshot returns the current state of each server, in the order of
+// servers.
+func (st *stats) snapshot(servers []string) *snapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snap := &snapshot{Elapsed: duration(time.Since(st.start)), Links: len(st.links), Unknown: st.unknown}
+	byHost := make(map[string]*serverStats)
+	for _, s := range servers {
+		ss := &serverStats{Host: s, PeakFetches: st.peakFetching[s]}
+		byHost[s] = ss
+		snap.Servers = append(snap.Servers, ss)
+
+		var lat latencies
+		for key, r := range st.received[s] {
+			ss.Forwarded++
+			ss.Duplicates += r.count - 1
+			if r.wrongTitle {
+				ss.WrongTitles++
+			}
+			lat = append(lat, r.first.Sub(st.links[key].added))
+		}
+		lat.sort()
+		ss.Propagation = lat.summary()
+	}
+	for key, l := range st.links {
+		ss := byHost[l.server]
+		ss.Added++
+		if !l.titled {
+			ss.Untitled++
+		}
+		n := st.fetches[key]
+		ss.Fetches += n
+		if l.titled {
+			ss.ExtraFetches += n // titled links needn't be fetched at all
+		} else if n > 1 {
+			ss.ExtraFetches += n - 1
+		}
+	}
+	for _, ss := range snap.Servers {
+		ss.score(len(st.links))
+	}
+	return snap
+}
+
+// record takes a snapshot and adds it to the history.
+func (st *stats) record(servers []string) {
+	snap := st.snapshot(servers)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.history = append(st.history, snap)
+}
+
+// latencies holds measured latencies.
+type latencies []time.Duration
+
+func (ls latencies) sort() { slices.Sort(ls) }
+
+// percentile returns the pth percentile (0 < p <= 100) of the sorted
+// latencies, by the nearest-rank method.
+func (ls latencies) percentile(p float64) time.Duration {
+	if len(ls) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(float64(len(ls))*p/100)) - 1
+	return ls[min(max(i, 0), len(ls)-1)]
+}
+
+// latencySummary summarizes sorted latencies.
+type latencySummary struct {
+	Count         int
+	P50, P90, Max duration
+}
+
+func (ls latencies) summary() latencySummary {
+	s := latencySummary{Count: len(ls)}
+	if len(ls) > 0 {
+		s.P50, s.P90, s.Max = duration(ls.percentile(50)), duration(ls.percentile(90)), duration(ls[len(ls)-1])
+	}
+	return s
+}
+
+// duration is a time.Duration that is JSON-encoded as a string such as
+// "1.5s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d duration) String() string {
+	return time.Duration(d).Round(time.Millisecond).String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestStats_Snapshot tests that a snapshot counts each server's added links,
+// title fetches and forwarded links.
+func TestStats_Snapshot(t *testing.T) {
+	st := newStats([]string{"a", "b"})
+	st.added("a", &link{URL: "http://burrow.example.com/1", Title: "One"})
+	st.added("a", &link{URL: "http://burrow.example.com/2"})
+	st.added("b", &link{URL: "http://burrow.example.com/3"})
+
+	fetch := func(path string) func() {
+		return st.fetch(&url.URL{Scheme: "http", Host: "burrow.example.com", Path: path})
+	}
+	done1, done2 := fetch("/1"), fetch("/2") // two of a's links at once
+	done1()
+	done2()
+	fetch("/2")() // again
+	fetch("/3")()
+	fetch("/unknown")()
+
+	st.receive("a", &link{URL: "http://burrow.example.com/1", Title: "One"})
+	st.receive("a", &link{URL: "http://burrow.example.com/1", Title: "One"})
+	st.receive("a", &link{URL: "http://burrow.example.com/2", Title: "Wrong"})
+	st.receive("a", &link{URL: "http://burrow.example.com/3", Title: "fetched-3"})
+	st.receive("a", &link{URL: "http://burrow.example.com/unknown"})
+	st.receive("a", &link{URL: "http://burrow.example.com" + adversaryPath + "x"})
+
+	snap := st.snapshot([]string{"a", "b"})
+	if snap.Links != 3 || snap.Unknown != 1 {
+		t.Errorf("got %d links and %d unknown, want 3 and 1", snap.Links, snap.Unknown)
+	}
+	type counts struct {
+		Added, Untitled, Fetches, ExtraFetches, PeakFetches int
+		Forwarded, Duplicates, WrongTitles                  int
+	}
+	for i, want := range []counts{
+		// a's titled link was fetched needlessly, and its untitled link
+		// twice.
+		{Added: 2, Untitled: 1, Fetches: 3, ExtraFetches: 2, PeakFetches: 2, Forwarded: 3, Duplicates: 1, WrongTitles: 1},
+		{Added: 1, Untitled: 1, Fetches: 1, PeakFetches: 1},
+	} {
+		ss := snap.Servers[i]
+		got := counts{ss.Added, ss.Untitled, ss.Fetches, ss.ExtraFetches, ss.PeakFetches, ss.Forwarded, ss.Duplicates, ss.WrongTitles}
+		if got != want {
+			t.Errorf("server %s: got %+v, want %+v", ss.Host, got, want)
+		}
+	}
+	if p := snap.Servers[0].Propagation; p.Count != 3 {
+		t.Errorf("got propagation of %d links, want 3", p.Count)
+	}
+	if g := snap.Servers[1].Grade; g != "B" {
+		t.Errorf("got grade %s for server that forwarded nothing, want B", g)
+	}
+}
+
+// TestLatencies_Percentile tests percentiles by the nearest-rank method.
+func TestLatencies_Percentile(t *testing.T) {
+	ls := latencies{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for _, test := range []struct {
+		ls   latencies
+		p    float64
+		want time.Duration
+	}{
+		{ls, 10, 10},
+		{ls, 11, 20},
+		{ls, 50, 50},
+		{ls, 90, 90},
+		{ls, 99, 100},
+		{ls, 100, 100},
+		{latencies{7}, 50, 7},
+		{nil, 90, 0},
+	} {
+		if got := test.ls.percentile(test.p); got != test.want {
+			t.Errorf("percentile %v of %v: got %d, want %d", test.p, test.ls, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var convergeTimeout = flag.Duration("converge-timeout", 10*time.Second, "how long to wait for every server to have every link (0 to not check; it is skipped for servers that don't list their links at GET /links)")
+
+// convergePollInterval is how often servers' link lists are fetched while
+// waiting for them to converge (which bounds the precision of convergence
+// times).
+const convergePollInterval = 10 * time.Millisecond
+
+// maxListedURLs is how many missing/extra/duplicate URLs are listed for each
+// server in the report (unless -v is set).
+const maxListedURLs = 5
+
+// serverReport describes how a server's links differ from the links that
+// were added.
+type serverReport struct {
+	host       string
+	missing    []string // added but not in the server's list
+	extra      []string // in the server's list but never added
+	duplicates []string // in the server's list more than once
+	err        error    // error fetching the server's list
+}
+
+func (r *serverReport) ok() bool {
+	return r.err == nil && len(r.missing) == 0 && len(r.extra) == 0 && len(r.duplicates) == 0
+}
+
+// listLinks returns the URLs of the links on the server at host (in the
+// order it lists them, including any duplicates).
+func listLinks(host string) ([]string, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/links", host))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /links: HTTP status %d", resp.StatusCode)
+	}
+	var ls []*link
+	if err := json.NewDecoder(resp.Body).Decode(&ls); err != nil {
+		return nil, fmt.Errorf("GET /links: %s", err)
+	}
+	urls := make([]string, len(ls))
+	for i, l := range ls {
+		urls[i] = l.URL
+	}
+	return urls, nil
+}
+
+// canListLinks reports whether the server at host lists its links as JSON
+// at GET /links. Servers built from the earlier parts of the workshop don't
+// (they respond with 404 or 405, or with their HTML homepage), so their
+// convergence can't be checked.
+func canListLinks(host string) (bool, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/links", host))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return false, nil
+	}
+	var ls []*link
+	return json.NewDecoder(resp.Body).Decode(&ls) == nil, nil
+}
+
+// compareLinks compares the URLs listed by a server with the URLs that
+// were added.
+func compareLinks(host string, urls []string, added map[string]time.Time) *serverReport {
+	r := &serverReport{host: host}
+	count := make(map[string]int, len(urls))
+	for _, url := range urls {
+		count[url]++
+		switch {
+		case count[url] == 2:
+			r.duplicates = append(r.duplicates, url)
+		case count[url] > 1:
+		case added[url].IsZero():
+			r.extra = append(r.extra, url)
+		}
+	}
+	for url := range added {
+		if count[url] == 0 {
+			r.missing = append(r.missing, url)
+		}
+	}
+	sort.Strings(r.missing)
+	return r
+}
+
+// convergence tracks when each added link is first listed by each server.
+type convergence struct {
+	mu    sync.Mutex
+	added map[string]time.Time            // when each link (keyed by URL) was added
+	seen  map[string]map[string]time.Time // when each link was first listed by each server (keyed by host)
+}
+
+func newConvergence() *convergence {
+	return &convergence{added: make(map[string]time.Time), seen: make(map[string]map[string]time.Time)}
+}
+
+// linkAdded records that a link was added at time t.
+func (c *convergence) linkAdded(url string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.added[url] = t
+}
+
+// poll fetches every server's link list once, and compares it with the
+// added links. It reports whether every server has exactly the added
+// links.
+func (c *convergence) poll() (reports []*serverReport, converged bool) {
+	converged = true
+	for _, s := range servers {
+		urls, err := listLinks(s.host)
+		now := time.Now()
+		if err != nil {
+			reports = append(reports, &serverReport{host: s.host, err: err})
+			converged = false
+			continue
+		}
+		c.mu.Lock()
+		for _, url := range urls {
+			hosts, present := c.seen[url]
+			if !present {
+				hosts = make(map[string]time.Time, len(servers))
+				c.seen[url] = hosts
+			}
+			if _, present := hosts[s.host]; !present {
+				hosts[s.host] = now
+			}
+		}
+		r := compareLinks(s.host, urls, c.added)
+		c.mu.Unlock()
+		reports = append(reports, r)
+		converged = converged && r.ok()
+	}
+	return reports, converged
+}
+
+// watch polls servers until stop is closed, so that the time links take to
+// reach every server is measured while links are still being added.
+func (c *convergence) watch(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(convergePollInterval):
+		}
+		c.poll()
+	}
+}
+
+// await polls servers until every server has exactly the added links, or
+// until timeout passes. It returns the last report for each server, and the
+// time each link took to reach every server (for the links that did).
+func (c *convergence) await(timeout time.Duration) ([]*serverReport, map[string]time.Duration) {
+	deadline := time.Now().Add(timeout)
+	var reports []*serverReport
+	for {
+		var converged bool
+		if reports, converged = c.poll(); converged || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(convergePollInterval)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	times := make(map[string]time.Duration)
+	for url, t0 := range c.added {
+		hosts := c.seen[url]
+		if len(hosts) < len(servers) {
+			continue
+		}
+		var last time.Time
+		for _, t := range hosts {
+			if t.After(last) {
+				last = t
+			}
+		}
+		times[url] = max(last.Sub(t0), 0)
+	}
+	return reports, times
+}
+
+// convergenceResult is the outcome of waiting for servers to converge.
+type convergenceResult struct {
+	converged bool
+	times     latencies       // sorted times links took to reach every server (for those that did)
+	servers   []*serverReport // the last report for each server
+}
+
+// check waits (for up to timeout) for the servers to converge on the added
+// links.
+func (c *convergence) check(timeout time.Duration) *convergenceResult {
+	slog.Debug("Waiting for servers to converge", "timeout", timeout)
+	reports, times := c.await(timeout)
+	res := &convergenceResult{converged: true, servers: reports}
+	for _, d := range times {
+		res.times = append(res.times, d)
+	}
+	res.times.sort()
+	for _, r := range reports {
+		res.converged = res.converged && r.ok()
+	}
+	return res
+}
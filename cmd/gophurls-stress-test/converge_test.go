@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCompareLinks tests that compareLinks reports links that a server is
+// missing, has but weren't added, and lists more than once.
+func TestCompareLinks(t *testing.T) {
+	now := time.Now()
+	added := map[string]time.Time{"http://a": now, "http://b": now, "http://c": now}
+	for _, test := range []struct {
+		name                       string
+		urls                       []string
+		missing, extra, duplicates []string
+		ok                         bool
+	}{
+		{"converged", []string{"http://c", "http://a", "http://b"}, nil, nil, nil, true},
+		{"missing", []string{"http://b"}, []string{"http://a", "http://c"}, nil, nil, false},
+		{"extra", []string{"http://a", "http://b", "http://c", "http://x"}, nil, []string{"http://x"}, nil, false},
+		{"duplicates", []string{"http://a", "http://a", "http://b", "http://c", "http://a"}, nil, nil, []string{"http://a"}, false},
+		{"extra duplicate", []string{"http://x", "http://x"}, []string{"http://a", "http://b", "http://c"}, []string{"http://x"}, []string{"http://x"}, false},
+		{"empty", nil, []string{"http://a", "http://b", "http://c"}, nil, nil, false},
+	} {
+		r := compareLinks("host", test.urls, added)
+		if !reflect.DeepEqual(r.missing, test.missing) {
+			t.Errorf("%s: got missing %q, want %q", test.name, r.missing, test.missing)
+		}
+		if !reflect.DeepEqual(r.extra, test.extra) {
+			t.Errorf("%s: got extra %q, want %q", test.name, r.extra, test.extra)
+		}
+		if !reflect.DeepEqual(r.duplicates, test.duplicates) {
+			t.Errorf("%s: got duplicates %q, want %q", test.name, r.duplicates, test.duplicates)
+		}
+		if r.ok() != test.ok {
+			t.Errorf("%s: got ok %v, want %v", test.name, r.ok(), test.ok)
+		}
+	}
+}
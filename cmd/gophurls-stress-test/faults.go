@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	numRestarts       = flag.Int("restarts", 0, "number of times to stop and restart a (random) server while adding links")
+	restartSignal     = flag.String("restart-signal", "TERM", "signal to stop servers with when restarting them: TERM (which lets them shut down gracefully and save their links), or KILL (after which they only converge if they resync the links they lost from their peers)")
+	restartDowntime   = flag.Duration("restart-downtime", 500*time.Millisecond, "how long restarted servers stay down")
+	numPartitions     = flag.Int("partitions", 0, "number of times to partition the servers into two random groups that can't reach each other while adding links")
+	partitionDuration = flag.Duration("partition-duration", time.Second, "how long each partition lasts")
+	fetchLatency      = flag.Duration("fetch-latency", 0, "maximum latency (chosen uniformly at random for each request) of the fake server that servers fetch link titles from")
+	fetchErrorRate    = flag.Float64("fetch-error-rate", 0, "fraction (0 to 1) of title fetches that the fake server fails with HTTP 500")
+	seed              = flag.Uint64("seed", 0, "random seed for fault injection and random topologies (0 for a random seed)")
+)
+
+// rng chooses which servers to restart and partition, the fake server's
+// latency and errors, and random topologies.
+var (
+	rng   *rand.Rand
+	rngMu sync.Mutex
+)
+
+func randIntN(n int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.IntN(n)
+}
+
+func randFloat64() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Float64()
+}
+
+// faultyHandler wraps the fake server's handler h to add latency (chosen
+// uniformly at random up to maxLatency) and errors (at errorRate).
+func faultyHandler(h http.Handler, maxLatency time.Duration, errorRate float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxLatency > 0 {
+			time.Sleep(time.Duration(randFloat64() * float64(maxLatency)))
+		}
+		if errorRate > 0 && randFloat64() < errorRate {
+			slog.Debug("Fake server failing fetch", "path", r.URL.Path, "request_id", r.Header.Get(requestIDHeader))
+			http.Error(w, "injected error", http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// faults tracks the faults injected in the background, so that the run
+// doesn't end before they're over.
+var faults sync.WaitGroup
+
+// inject injects the fault f in the background.
+func inject(f fault) {
+	switch f.Type {
+	case "restart":
+		restart(f)
+	case "partition":
+		partition(f)
+	}
+}
+
+// restart stops (with f.Signal) and restarts the server f.Server (or a
+// random server that's up), in the background.
+func restart(f fault) {
+	var up []*server
+	for _, s := range servers {
+		if !s.isDown() {
+			up = append(up, s)
+		}
+	}
+	var s *server
+	switch {
+	case f.Server != nil:
+		if s = servers[*f.Server]; s.isDown() {
+			slog.Warn("Not restarting server, because it's already down", "server", s.host)
+			return
+		}
+	case len(up) <= 1:
+		slog.Warn("Not restarting a server, because only one is up")
+		return
+	default:
+		s = up[randIntN(len(up))]
+	}
+	s.setDown(true)
+	faults.Add(1)
+	go func() {
+		defer faults.Done()
+		defer s.setDown(false)
+		if err := restartServer(s, f.Signal, time.Duration(f.Downtime)); err != nil {
+			fatal("Error restarting server", "server", s.host, "err", err)
+		}
+	}()
+}
+
+func restartServer(s *server, signal string, downtime time.Duration) error {
+	slog.Info("Stopping server", "server", s.host, "signal", signal)
+	sig := syscall.SIGKILL
+	if signal == "TERM" {
+		sig = syscall.SIGTERM
+	}
+	if err := s.cmd.Process.Signal(sig); err != nil {
+		return err
+	}
+	s.cmd.Wait() // an error is expected if it was killed
+	time.Sleep(downtime)
+
+	slog.Info("Restarting server", "server", s.host)
+	if err := s.start(); err != nil {
+		return err
+	}
+	if err := waitServerReady(s, time.Now().Add(readyTimeout)); err != nil {
+		return err
+	}
+	// Servers don't save their peers, so add them again.
+	return setPeers(s)
+}
+
+// proxies holds the proxies that servers send links to their peers through
+// (if the scenario has partitions), keyed by the sending and receiving servers' hosts.
+var proxies = make(map[[2]string]*proxy)
+
+// startProxies starts a proxy from each server to each of its peers.
+func startProxies() error {
+	for _, from := range servers {
+		for _, to := range from.peers {
+			p, err := startProxy(to.host)
+			if err != nil {
+				return err
+			}
+			proxies[[2]string{from.host, to.host}] = p
+		}
+	}
+	return nil
+}
+
+// peerAddr returns the address that the server at from should use to reach
+// its peer at to.
+func peerAddr(from, to string) string {
+	if p, present := proxies[[2]string{from, to}]; present {
+		return p.ln.Addr().String()
+	}
+	return to
+}
+
+// partitionGroups returns the group of each of n servers (by index) in the
+// partition f: server i is in group g+1 if f.Groups[g] includes i, and in
+// group 0 if no group does. If f.Groups is empty, the servers are split
+// into two random groups, 0 and 1, neither of them empty.
+func partitionGroups(f fault, n int) []int {
+	group := make([]int, n)
+	if len(f.Groups) > 0 {
+		for g, indexes := range f.Groups {
+			for _, i := range indexes {
+				group[i] = g + 1
+			}
+		}
+		return group
+	}
+	rngMu.Lock()
+	order := rng.Perm(n)
+	rngMu.Unlock()
+	for _, i := range order[:1+randIntN(n-1)] {
+		group[i] = 1
+	}
+	return group
+}
+
+// partition partitions the servers into f.Groups (or two random groups) that
+// can't reach each other for f.Duration, in the background.
+func partition(f fault) {
+	group := make(map[*server]int)
+	for i, g := range partitionGroups(f, len(servers)) {
+		group[servers[i]] = g
+	}
+
+	var cut []*proxy
+	groups := make(map[int][]string)
+	for _, s := range servers {
+		groups[group[s]] = append(groups[group[s]], s.host)
+		for _, peer := range s.peers {
+			if group[peer] != group[s] {
+				cut = append(cut, proxies[[2]string{s.host, peer.host}])
+			}
+		}
+	}
+	var groupHosts [][]string
+	for g := range max(len(f.Groups), 1) + 1 {
+		if len(groups[g]) > 0 {
+			groupHosts = append(groupHosts, groups[g])
+		}
+	}
+
+	slog.Info("Partitioning servers", "groups", groupHosts, "duration", time.Duration(f.Duration))
+	for _, p := range cut {
+		p.setBlocked(true)
+	}
+	faults.Add(1)
+	time.AfterFunc(time.Duration(f.Duration), func() {
+		defer faults.Done()
+		slog.Info("Healing partition", "groups", groupHosts)
+		for _, p := range cut {
+			p.setBlocked(false)
+		}
+	})
+}
+
+// proxy is a TCP proxy to a server, which can be blocked to simulate a
+// network partition.
+type proxy struct {
+	to string
+	ln net.Listener
+
+	mu      sync.Mutex
+	blocked bool
+	conns   map[net.Conn]struct{} // open connections (from clients and to the server)
+}
+
+func startProxy(to string) (*proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{to: to, ln: ln, conns: make(map[net.Conn]struct{})}
+	go p.serve()
+	return p, nil
+}
+
+func (p *proxy) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(c)
+	}
+}
+
+// forward copies data between c and a new connection to the server, unless
+// the proxy is blocked (in which case c is closed).
+func (p *proxy) forward(c net.Conn) {
+	if !p.track(c) {
+		c.Close()
+		return
+	}
+	defer p.untrack(c)
+	s, err := net.Dial("tcp", p.to)
+	if err != nil {
+		return
+	}
+	if !p.track(s) {
+		s.Close()
+		return
+	}
+	defer p.untrack(s)
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(s, c); done <- struct{}{} }()
+	go func() { io.Copy(c, s); done <- struct{}{} }()
+	<-done // when either side closes, close both
+}
+
+// track records the open connection c, returning false if the proxy is
+// blocked.
+func (p *proxy) track(c net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.blocked {
+		return false
+	}
+	p.conns[c] = struct{}{}
+	return true
+}
+
+func (p *proxy) untrack(c net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+	c.Close()
+}
+
+// setBlocked blocks or unblocks the proxy. Blocking it closes its open
+// connections, and closes new connections as soon as they're accepted.
+func (p *proxy) setBlocked(blocked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocked = blocked
+	if blocked {
+		for c := range p.conns {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestPartitionGroups tests that partitions put servers in the groups given
+// by their indexes, with the servers left out in a group of their own.
+func TestPartitionGroups(t *testing.T) {
+	for _, test := range []struct {
+		groups [][]int
+		n      int
+		want   []int
+	}{
+		{[][]int{{0}}, 3, []int{1, 0, 0}},
+		{[][]int{{0, 2}, {1}}, 3, []int{1, 2, 1}},
+		{[][]int{{3}, {1, 0}}, 5, []int{2, 2, 0, 1, 0}},
+		{[][]int{{}, {1}}, 2, []int{0, 2}},
+	} {
+		if got := partitionGroups(fault{Type: "partition", Groups: test.groups}, test.n); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("groups %v of %d servers: got %v, want %v", test.groups, test.n, got, test.want)
+		}
+	}
+}
+
+// TestPartitionGroups_Random tests that partitions without groups split the
+// servers into two nonempty groups.
+func TestPartitionGroups_Random(t *testing.T) {
+	rng, _ = newRand(1)
+	for n := 2; n <= 5; n++ {
+		for range 20 {
+			got := partitionGroups(fault{Type: "partition"}, n)
+			var sizes [2]int
+			for _, g := range got {
+				if g != 0 && g != 1 {
+					t.Fatalf("%d servers: got groups %v, want only groups 0 and 1", n, got)
+				}
+				sizes[g]++
+			}
+			if sizes[0] == 0 || sizes[1] == 0 {
+				t.Errorf("%d servers: got groups %v, want two nonempty groups", n, got)
+			}
+		}
+	}
+}
+
+// TestFaultyHandler tests that the fake server fails fetches at the given
+// error rate.
+func TestFaultyHandler(t *testing.T) {
+	rng, _ = newRand(1)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, test := range []struct {
+		errorRate float64
+		want      int
+	}{
+		{0, http.StatusOK},
+		{1, http.StatusInternalServerError},
+	} {
+		rec := httptest.NewRecorder()
+		faultyHandler(ok, 0, test.errorRate).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != test.want {
+			t.Errorf("error rate %v: got status %d, want %d", test.errorRate, rec.Code, test.want)
+		}
+	}
+}
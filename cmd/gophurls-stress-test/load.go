@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	arrival      = flag.String("arrival", "constant", "arrival process of links (with -rate): constant (evenly spaced) or poisson")
+	concurrency  = flag.Int("concurrency", 16, "maximum number of POST /links requests in flight")
+	loadDuration = flag.Duration("duration", 0, "how long to add links for (with -rate), instead of adding -links links")
+)
+
+// loadResult holds what was measured while adding links.
+type loadResult struct {
+	mu sync.Mutex
+
+	added     int
+	addErrors int
+
+	// latency is the time from when each request was scheduled to be sent
+	// (by the arrival process) to when its response was received. It
+	// includes the time requests waited for a free slot (with
+	// -concurrency), so that a slow server doesn't hide its slowness by
+	// slowing down the load (that is, it avoids coordinated omission).
+	latency latencies
+
+	// serviceTime is the time from when each request was actually sent to
+	// when its response was received.
+	serviceTime latencies
+
+	elapsed time.Duration // how long it took to add all links
+}
+
+// addLinks adds links to the servers as described by the scenario: links
+// arrive at sc.Rate per second (or as fast as possible) and are added by up
+// to sc.Concurrency concurrent requests, independently of how fast servers
+// respond. Faults are injected at their scheduled times, and links are
+// recorded in conv as they're added.
+func addLinks(sc *scenario, fakeServerURL string, conv *convergence) *loadResult {
+	r := &loadResult{}
+	t0 := time.Now()
+	for _, f := range sc.Faults {
+		if f.At > 0 {
+			faults.Add(1)
+			time.AfterFunc(time.Duration(f.At), func() {
+				defer faults.Done()
+				inject(f)
+			})
+		}
+	}
+
+	sem := make(chan struct{}, sc.Concurrency)
+	var wg sync.WaitGroup
+	var next time.Duration // when the next link arrives, relative to t0
+	for i := 0; sc.Duration > 0 || i < sc.Links; i++ {
+		if sc.Rate > 0 {
+			if i > 0 {
+				next += sc.interarrival()
+			}
+			if sc.Duration > 0 && next >= time.Duration(sc.Duration) {
+				break
+			}
+			time.Sleep(time.Until(t0.Add(next)))
+		}
+		scheduled := time.Now()
+		if sc.Rate > 0 {
+			scheduled = t0.Add(next)
+		}
+		for _, f := range sc.Faults {
+			if f.At == 0 && f.AtLink == i {
+				inject(f)
+			}
+		}
+		sem <- struct{}{}
+
+		// Cycle through the servers (that are up).
+		s := servers[i%len(servers)]
+		for j := 1; s.isDown() && j < len(servers); j++ {
+			s = servers[(i+j)%len(servers)]
+		}
+
+		// Add titles to TitleRatio of links (spread evenly).
+		link := &link{
+			URL: fmt.Sprintf("%s/page-%d", fakeServerURL, i),
+		}
+		if int(float64(i+1)*sc.TitleRatio) > int(float64(i)*sc.TitleRatio) {
+			link.Title = fmt.Sprintf("unfetched-%d", i)
+		}
+
+		// Give each link a request ID, so that its journey through the
+		// servers can be followed in their logs.
+		requestID := fmt.Sprintf("stress-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			sent := time.Now()
+			err := addLink(s.host, link, requestID)
+			done := time.Now()
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err != nil {
+				slog.Error("Error adding link", "server", s.host, "url", link.URL, "err", err, "request_id", requestID)
+				r.addErrors++
+				return
+			}
+			slog.Debug("Added link", "server", s.host, "url", link.URL, "title", link.Title, "request_id", requestID)
+			r.added++
+			r.latency = append(r.latency, done.Sub(scheduled))
+			r.serviceTime = append(r.serviceTime, done.Sub(sent))
+			conv.linkAdded(link.URL, sent)
+		}()
+	}
+	wg.Wait()
+	r.elapsed = time.Since(t0)
+	r.latency.sort()
+	r.serviceTime.sort()
+	return r
+}
+
+// interarrival returns the time until the next link arrives.
+func (sc *scenario) interarrival() time.Duration {
+	mean := float64(time.Second) / sc.Rate
+	if sc.Arrival == "poisson" {
+		rngMu.Lock()
+		defer rngMu.Unlock()
+		return time.Duration(rng.ExpFloat64() * mean)
+	}
+	return time.Duration(mean)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	reportFormat = flag.String("report", "text", "format of the report printed at the end: text or json")
+	hgrmDir      = flag.String("hgrm-dir", "", "directory to write latency histograms to, in HdrHistogram's percentile distribution (.hgrm) format")
+)
+
+// latencies holds measured latencies. They're kept (rather than counted in
+// buckets) so that percentiles are exact.
+type latencies []time.Duration
+
+func (ls latencies) sort() { slices.Sort(ls) }
+
+// percentile returns the pth percentile (0 < p <= 100) of the sorted
+// latencies, by the nearest-rank method.
+func (ls latencies) percentile(p float64) time.Duration {
+	if len(ls) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(float64(len(ls))*p/100)) - 1
+	return ls[min(max(i, 0), len(ls)-1)]
+}
+
+func (ls latencies) mean() time.Duration {
+	if len(ls) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range ls {
+		sum += l
+	}
+	return sum / time.Duration(len(ls))
+}
+
+func (ls latencies) stddev() time.Duration {
+	if len(ls) == 0 {
+		return 0
+	}
+	mean := float64(ls.mean())
+	var sum float64
+	for _, l := range ls {
+		sum += (float64(l) - mean) * (float64(l) - mean)
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(ls))))
+}
+
+// latencySummary summarizes sorted latencies.
+type latencySummary struct {
+	Count                          int
+	Min, Mean, P50, P90, P99, P999 duration
+	Max                            duration
+}
+
+func (ls latencies) summary() latencySummary {
+	s := latencySummary{Count: len(ls)}
+	if len(ls) > 0 {
+		s.Min, s.Max, s.Mean = duration(ls[0]), duration(ls[len(ls)-1]), duration(ls.mean())
+		s.P50, s.P90 = duration(ls.percentile(50)), duration(ls.percentile(90))
+		s.P99, s.P999 = duration(ls.percentile(99)), duration(ls.percentile(99.9))
+	}
+	return s
+}
+
+func (s latencySummary) String() string {
+	if s.Count == 0 {
+		return "count=0"
+	}
+	d := func(v duration) time.Duration { return time.Duration(v).Round(time.Microsecond) }
+	return fmt.Sprintf("count=%d min=%s mean=%s p50=%s p90=%s p99=%s p99.9=%s max=%s",
+		s.Count, d(s.Min), d(s.Mean), d(s.P50), d(s.P90), d(s.P99), d(s.P999), d(s.Max))
+}
+
+// hgrmTicksPerHalfDistance is how many percentiles are reported in each
+// half of the remaining distance to 100%, as in HdrHistogram's output.
+const hgrmTicksPerHalfDistance = 5
+
+// writeHgrm writes the sorted latencies to w in HdrHistogram's percentile
+// distribution format (with values in milliseconds), which HdrHistogram's
+// plotter (https://hdrhistogram.github.io/HdrHistogram/plotFiles.html) can
+// plot.
+func (ls latencies) writeHgrm(w io.Writer) error {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	n := len(ls)
+	for p := 0.0; n > 0; {
+		rank := max(1, int(math.Ceil(p/100*float64(n))))
+		if rank >= n {
+			break
+		}
+		fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", ms(ls[rank-1]), p/100, rank, 1/(1-p/100))
+		// Report percentiles more densely as they approach 100%.
+		ticks := hgrmTicksPerHalfDistance * math.Pow(2, math.Floor(math.Log2(100/(100-p)))+1)
+		p += 100 / ticks
+	}
+	if n > 0 {
+		fmt.Fprintf(w, "%12.3f %2.12f %10d\n", ms(ls[n-1]), 1.0, n)
+	}
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", ms(ls.mean()), ms(ls.stddev()))
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", ms(ls.percentile(100)), n)
+	_, err := fmt.Fprintf(w, "#[Buckets = %12d, SubBuckets     = %12d]\n", 0, 0)
+	return err
+}
+
+// report is the report printed at the end of a run.
+type report struct {
+	Scenario string `json:",omitempty"`
+	Seed     uint64
+	Elapsed  duration
+
+	LinksAdded   int
+	AddErrors    int
+	Throughput   float64 // links added per second
+	TitleFetches int
+
+	// PostLatency is the latency of POST /links requests, from when they
+	// were scheduled to be sent. PostServiceTime is from when they were
+	// actually sent.
+	PostLatency     latencySummary
+	PostServiceTime latencySummary
+
+	// Propagation is the time links took to reach every server (for those
+	// that did).
+	Propagation      latencySummary
+	Converged        bool
+	LinksOnAll       int                // links on every server
+	ServerDivergence []serverDivergence `json:",omitempty"`
+
+	AssertionFailures []string `json:",omitempty"`
+}
+
+// serverDivergence is how a server's links differ from the links that were
+// added.
+type serverDivergence struct {
+	Host                       string
+	Missing, Extra, Duplicates []string `json:",omitempty"`
+	Error                      string   `json:",omitempty"`
+}
+
+func newReport(sc *scenario, r *runResult) *report {
+	rep := &report{
+		Scenario:        sc.Name,
+		Seed:            r.seed,
+		Elapsed:         duration(r.elapsed),
+		LinksAdded:      r.load.added,
+		AddErrors:       r.load.addErrors,
+		TitleFetches:    r.fetches,
+		PostLatency:     r.load.latency.summary(),
+		PostServiceTime: r.load.serviceTime.summary(),
+	}
+	if r.load.elapsed > 0 {
+		rep.Throughput = float64(r.load.added) / r.load.elapsed.Seconds()
+	}
+	if c := r.convergence; c != nil {
+		rep.Propagation = c.times.summary()
+		rep.Converged = c.converged
+		rep.LinksOnAll = len(c.times)
+		for _, sr := range c.servers {
+			if sr.ok() {
+				continue
+			}
+			d := serverDivergence{Host: sr.host, Missing: sr.missing, Extra: sr.extra, Duplicates: sr.duplicates}
+			if sr.err != nil {
+				d.Error = sr.err.Error()
+			}
+			rep.ServerDivergence = append(rep.ServerDivergence, d)
+		}
+	}
+	return rep
+}
+
+// writeText writes the report in a human-readable format.
+func (rep *report) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Total time elapsed: %s\n", time.Duration(rep.Elapsed))
+	fmt.Fprintf(w, "# link fetches: %d\n", rep.TitleFetches)
+	fmt.Fprintf(w, "# links added: %d (%.1f/s)\n", rep.LinksAdded, rep.Throughput)
+	if rep.AddErrors > 0 {
+		fmt.Fprintf(w, "# links that failed to add: %d\n", rep.AddErrors)
+	}
+	fmt.Fprintf(w, "POST /links latency: %s\n", rep.PostLatency)
+	fmt.Fprintf(w, "POST /links service time: %s\n", rep.PostServiceTime)
+	if rep.Propagation.Count > 0 || rep.ServerDivergence != nil {
+		fmt.Fprintf(w, "# links on every server: %d/%d\n", rep.LinksOnAll, rep.LinksAdded)
+		fmt.Fprintf(w, "Propagation latency: %s\n", rep.Propagation)
+	}
+	for _, d := range rep.ServerDivergence {
+		if d.Error != "" {
+			fmt.Fprintf(w, "Server %s: error listing links: %s\n", d.Host, d.Error)
+			continue
+		}
+		fmt.Fprintf(w, "Server %s: %d missing, %d extra, %d duplicate links\n", d.Host, len(d.Missing), len(d.Extra), len(d.Duplicates))
+		writeURLs(w, "missing", d.Missing)
+		writeURLs(w, "extra", d.Extra)
+		writeURLs(w, "duplicate", d.Duplicates)
+	}
+	for _, f := range rep.AssertionFailures {
+		fmt.Fprintf(w, "Assertion failed: %s\n", f)
+	}
+}
+
+func writeURLs(w io.Writer, label string, urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+	shown := urls
+	if !*verbose && len(shown) > maxListedURLs {
+		shown = shown[:maxListedURLs]
+	}
+	more := ""
+	if len(shown) < len(urls) {
+		more = fmt.Sprintf(" (and %d more; use -v to list all)", len(urls)-len(shown))
+	}
+	fmt.Fprintf(w, "  %s: %s%s\n", label, strings.Join(shown, " "), more)
+}
+
+// writeReport writes the report in the -report format to stdout, and the
+// latency histograms to -hgrm-dir (if set).
+func writeReport(rep *report, r *runResult) error {
+	switch *reportFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	default:
+		rep.writeText(os.Stdout)
+	}
+
+	if *hgrmDir == "" {
+		return nil
+	}
+	hs := map[string]latencies{
+		"post-latency.hgrm":      r.load.latency,
+		"post-service-time.hgrm": r.load.serviceTime,
+	}
+	if r.convergence != nil {
+		hs["propagation.hgrm"] = r.convergence.times
+	}
+	for name, ls := range hs {
+		f, err := os.Create(filepath.Join(*hgrmDir, name))
+		if err != nil {
+			return err
+		}
+		err = ls.writeHgrm(f)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLatencies_Percentile tests percentiles by the nearest-rank method.
+func TestLatencies_Percentile(t *testing.T) {
+	ls := latencies{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for _, test := range []struct {
+		ls   latencies
+		p    float64
+		want time.Duration
+	}{
+		{ls, 10, 10},
+		{ls, 11, 20},
+		{ls, 50, 50},
+		{ls, 90, 90},
+		{ls, 99, 100},
+		{ls, 99.9, 100},
+		{ls, 100, 100},
+		{ls, 0.001, 10},
+		{latencies{7}, 50, 7},
+		{latencies{7}, 100, 7},
+		{nil, 99, 0},
+	} {
+		if got := test.ls.percentile(test.p); got != test.want {
+			t.Errorf("percentile %v of %v: got %d, want %d", test.p, test.ls, got, test.want)
+		}
+	}
+}
+
+// TestLatencies_WriteHgrm tests that the percentile distribution lists the
+// latency at each percentile, ending with the maximum, followed by the
+// summary lines HdrHistogram's plotter expects.
+func TestLatencies_WriteHgrm(t *testing.T) {
+	for _, n := range []int{0, 1, 4, 1000} {
+		ls := make(latencies, n)
+		for i := range ls {
+			ls[i] = time.Duration(i+1) * time.Millisecond
+		}
+		var buf bytes.Buffer
+		if err := ls.writeHgrm(&buf); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) < 5 || !strings.Contains(lines[0], "Percentile") || lines[1] != "" {
+			t.Fatalf("%d latencies: got %q, want a header, a blank line, rows and a summary", n, buf.String())
+		}
+		rows, summary := lines[2:len(lines)-3], lines[len(lines)-3:]
+
+		lastP := -1.0
+		for i, row := range rows {
+			var value, p float64
+			var count int
+			if _, err := fmt.Sscan(row, &value, &p, &count); err != nil {
+				t.Fatalf("%d latencies: bad row %q: %s", n, row, err)
+			}
+			if p <= lastP {
+				t.Errorf("%d latencies: row %q: percentile not greater than the previous row's %v", n, row, lastP)
+			}
+			lastP = p
+			if want := ls[count-1]; value != float64(want)/float64(time.Millisecond) {
+				t.Errorf("%d latencies: row %q: got value %v, want %v (the latency at count %d)", n, row, value, want, count)
+			}
+			if i == len(rows)-1 && (p != 1 || count != n) {
+				t.Errorf("%d latencies: got last row %q, want percentile 1 and count %d", n, row, n)
+			}
+		}
+		if n == 0 && len(rows) != 0 {
+			t.Errorf("no latencies: got rows %q, want none", rows)
+		}
+
+		maxMs := float64(n)
+		wantSummary := []string{
+			fmt.Sprintf("#[Mean    = %12.3f, StdDeviation   = %12.3f]", float64(ls.mean())/float64(time.Millisecond), float64(ls.stddev())/float64(time.Millisecond)),
+			fmt.Sprintf("#[Max     = %12.3f, Total count    = %12d]", maxMs, n),
+			fmt.Sprintf("#[Buckets = %12d, SubBuckets     = %12d]", 0, 0),
+		}
+		for i, want := range wantSummary {
+			if summary[i] != want {
+				t.Errorf("%d latencies: got summary line %q, want %q", n, summary[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+// scenario describes a stress test run: the servers and how they're
+// connected, the links that are added to them, the faults injected while
+// adding links, and what must hold at the end. Scenarios are read from JSON
+// files (with -scenario); fields missing from a file default to the values
+// of the corresponding flags.
+type scenario struct {
+	Name string `json:",omitempty"`
+
+	Servers int
+	// Topology is how servers are peered: "mesh" (every server is a peer of
+	// every other), "ring", "star" (the first server is a peer of all
+	// others, which are its peers), or "random" (a random connected graph in
+	// which servers have at least Degree peers).
+	Topology   string
+	Degree     int      `json:",omitempty"`
+	ServerArgs []string `json:",omitempty"` // extra command-line arguments for the servers
+
+	// Links is how many links are added, unless Duration is set, in which
+	// case links are added for that long.
+	Links    int
+	Duration duration `json:",omitzero"`
+	// Rate is how many links arrive per second (0 to add them as fast as
+	// possible), and Arrival is how their arrivals are spaced: "constant"
+	// (evenly) or "poisson" (randomly, as a Poisson process). Links are
+	// added when they arrive, by up to Concurrency requests at once,
+	// regardless of how long earlier requests take.
+	Rate        float64 `json:",omitempty"`
+	Arrival     string
+	Concurrency int
+	// TitleRatio is the fraction of links that are added with titles (the
+	// rest have their titles fetched by the servers).
+	TitleRatio float64
+
+	// Fetch describes faults of the fake server that servers fetch link
+	// titles from.
+	Fetch struct {
+		Latency   duration // maximum latency, chosen uniformly at random for each fetch
+		ErrorRate float64  // fraction of fetches that fail with HTTP 500
+	}
+
+	Faults []fault `json:",omitempty"`
+
+	Assert assertions
+
+	// Seed is the random seed for choosing faults, fetch latencies and
+	// random topologies (0 for a random seed).
+	Seed uint64 `json:",omitempty"`
+}
+
+// fault is a fault injected while adding links.
+type fault struct {
+	// Type is "restart" (stop a server and start it again) or "partition"
+	// (split the servers into groups that can't reach each other).
+	Type string
+
+	// The fault is injected At this long after links start being added if
+	// At is set, or else just before the AtLink'th link (from 0) is added.
+	At     duration `json:",omitzero"`
+	AtLink int      `json:",omitempty"`
+
+	// For restarts:
+	Server   *int     `json:",omitempty"` // index of the server to restart (a random server if omitted)
+	Signal   string   `json:",omitempty"` // TERM (the default, letting the server save its links) or KILL (which needs resync to converge)
+	Downtime duration `json:",omitzero"`  // how long the server stays down
+
+	// For partitions:
+	Groups   [][]int  `json:",omitempty"` // server indexes in each group (servers not in any group form another group); two random groups if omitted
+	Duration duration `json:",omitzero"`  // how long the partition lasts
+}
+
+// assertions are what must hold at the end of a run.
+type assertions struct {
+	// Converge is whether every server must end up with every link, within
+	// ConvergeTimeout after the last link is added and faults are over.
+	Converge        bool
+	ConvergeTimeout duration
+
+	// MaxConvergenceP99 and MaxConvergence bound the 99th percentile and
+	// maximum time taken for links to reach every server (if set).
+	MaxConvergenceP99 duration `json:",omitzero"`
+	MaxConvergence    duration `json:",omitzero"`
+
+	// MaxPostLatencyP99 bounds the 99th percentile latency of POST /links
+	// (if set).
+	MaxPostLatencyP99 duration `json:",omitzero"`
+
+	MaxAddErrors int  // how many links may fail to be added
+	MaxFetches   *int `json:",omitempty"` // how many titles may be fetched (if set)
+}
+
+// duration is a time.Duration that is a string (such as "1.5s") in JSON.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("bad duration %s (want a string such as \"1.5s\")", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// scenarioFromFlags returns the scenario described by the command-line
+// flags.
+func scenarioFromFlags() *scenario {
+	sc := &scenario{
+		Servers:     *numServers,
+		Topology:    *topology,
+		Degree:      *degree,
+		ServerArgs:  strings.Fields(*serverArgs),
+		Links:       *numLinks,
+		Duration:    duration(*loadDuration),
+		Rate:        *rate,
+		Arrival:     *arrival,
+		Concurrency: *concurrency,
+		TitleRatio:  *titleRatio,
+		Seed:        *seed,
+	}
+	sc.Fetch.Latency = duration(*fetchLatency)
+	sc.Fetch.ErrorRate = *fetchErrorRate
+	for k := 1; k <= *numRestarts; k++ {
+		sc.Faults = append(sc.Faults, fault{Type: "restart", AtLink: k * *numLinks / (*numRestarts + 1), Signal: *restartSignal, Downtime: duration(*restartDowntime)})
+	}
+	for k := 1; k <= *numPartitions; k++ {
+		sc.Faults = append(sc.Faults, fault{Type: "partition", AtLink: k * *numLinks / (*numPartitions + 1), Duration: duration(*partitionDuration)})
+	}
+	sc.Assert = assertions{Converge: *convergeTimeout > 0, ConvergeTimeout: duration(*convergeTimeout)}
+	return sc
+}
+
+// readScenario reads the scenario in the JSON file at path. Fields missing
+// from the file are taken from defaults.
+func readScenario(path string, defaults *scenario) (*scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := *defaults
+	sc.Faults = nil // faults given by flags aren't merged with the file's
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &sc, nil
+}
+
+// validate checks that sc makes sense, and fills in defaults for its
+// faults.
+func (sc *scenario) validate() error {
+	if sc.Servers < 1 {
+		return errors.New("there must be at least 1 server")
+	}
+	if sc.Links < 1 && sc.Duration == 0 {
+		return errors.New("there must be at least 1 link")
+	}
+	if sc.Duration < 0 || (sc.Duration > 0 && sc.Rate == 0) {
+		return errors.New("duration must not be negative, and requires a rate")
+	}
+	if sc.Arrival != "constant" && sc.Arrival != "poisson" {
+		return fmt.Errorf("bad arrival process %q (want constant or poisson)", sc.Arrival)
+	}
+	if sc.Concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	switch sc.Topology {
+	case "mesh", "ring", "star":
+	case "random":
+		if sc.Degree < 1 {
+			return errors.New("random topologies need a degree of at least 1")
+		}
+	default:
+		return fmt.Errorf("bad topology %q (want mesh, ring, star or random)", sc.Topology)
+	}
+	if sc.Rate < 0 {
+		return errors.New("rate must not be negative")
+	}
+	if sc.TitleRatio < 0 || sc.TitleRatio > 1 {
+		return errors.New("title ratio must be between 0 and 1")
+	}
+	if sc.Fetch.ErrorRate < 0 || sc.Fetch.ErrorRate > 1 {
+		return errors.New("fetch error rate must be between 0 and 1")
+	}
+	for i := range sc.Faults {
+		f := &sc.Faults[i]
+		if f.At < 0 || f.AtLink < 0 || (sc.Duration == 0 && f.AtLink >= sc.Links) {
+			return fmt.Errorf("fault %d: bad time (At must not be negative, and AtLink must be less than the number of links)", i)
+		}
+		switch f.Type {
+		case "restart":
+			if f.Server != nil && (*f.Server < 0 || *f.Server >= sc.Servers) {
+				return fmt.Errorf("fault %d: no server %d", i, *f.Server)
+			}
+			if f.Signal == "" {
+				f.Signal = *restartSignal
+			}
+			if f.Signal != "KILL" && f.Signal != "TERM" {
+				return fmt.Errorf("fault %d: bad signal %q (want KILL or TERM)", i, f.Signal)
+			}
+			if f.Downtime == 0 {
+				f.Downtime = duration(*restartDowntime)
+			}
+		case "partition":
+			if sc.Servers < 2 {
+				return fmt.Errorf("fault %d: partitions need at least 2 servers", i)
+			}
+			for _, g := range f.Groups {
+				for _, s := range g {
+					if s < 0 || s >= sc.Servers {
+						return fmt.Errorf("fault %d: no server %d", i, s)
+					}
+				}
+			}
+			if f.Duration == 0 {
+				f.Duration = duration(*partitionDuration)
+			}
+		default:
+			return fmt.Errorf("fault %d: bad type %q (want restart or partition)", i, f.Type)
+		}
+	}
+	if sc.Assert.Converge && sc.Assert.ConvergeTimeout <= 0 {
+		return errors.New("converging needs a positive timeout")
+	}
+	return nil
+}
+
+// hasFault reports whether sc has faults of the given type.
+func (sc *scenario) hasFault(typ string) bool {
+	for _, f := range sc.Faults {
+		if f.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIndexes returns the indexes of each server's peers, according to the
+// scenario's topology. Peering is symmetric: if a server is a peer of
+// another, the other is a peer of it.
+func (sc *scenario) peerIndexes() [][]int {
+	n := sc.Servers
+	adj := make([]map[int]bool, n)
+	for i := range adj {
+		adj[i] = make(map[int]bool)
+	}
+	connect := func(i, j int) {
+		if i != j {
+			adj[i][j], adj[j][i] = true, true
+		}
+	}
+	switch sc.Topology {
+	case "mesh":
+		for i := range n {
+			for j := range n {
+				connect(i, j)
+			}
+		}
+	case "ring":
+		for i := range n {
+			connect(i, (i+1)%n)
+		}
+	case "star":
+		for i := 1; i < n; i++ {
+			connect(0, i)
+		}
+	case "random":
+		// Connect each server to a random earlier one (in random order), so
+		// that the graph is connected, and then add random peers until each
+		// server has Degree peers.
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		rngMu.Lock()
+		rng.Shuffle(n, func(i, j int) { order[i], order[j] = order[j], order[i] })
+		rngMu.Unlock()
+		for k := 1; k < n; k++ {
+			connect(order[k], order[randIntN(k)])
+		}
+		for i := range n {
+			for len(adj[i]) < min(sc.Degree, n-1) {
+				connect(i, randIntN(n))
+			}
+		}
+	}
+	peers := make([][]int, n)
+	for i := range n {
+		for j := range n {
+			if adj[i][j] {
+				peers[i] = append(peers[i], j)
+			}
+		}
+	}
+	return peers
+}
+
+// checkAssertions checks the scenario's assertions against the results of a
+// run. It returns the assertions that failed.
+func (sc *scenario) checkAssertions(r *runResult) []string {
+	var failures []string
+	fail := func(format string, args ...any) {
+		failures = append(failures, fmt.Sprintf(format, args...))
+	}
+	a := sc.Assert
+	if c := r.convergence; c != nil {
+		if !c.converged {
+			fail("servers did not converge within %s", time.Duration(a.ConvergeTimeout))
+		}
+		if p99 := c.times.percentile(99); a.MaxConvergenceP99 > 0 && p99 > time.Duration(a.MaxConvergenceP99) {
+			fail("p99 time to convergence %s > %s", p99, time.Duration(a.MaxConvergenceP99))
+		}
+		if maxTime := c.times.percentile(100); a.MaxConvergence > 0 && maxTime > time.Duration(a.MaxConvergence) {
+			fail("maximum time to convergence %s > %s", maxTime, time.Duration(a.MaxConvergence))
+		}
+	}
+	if p99 := r.load.latency.percentile(99); a.MaxPostLatencyP99 > 0 && p99 > time.Duration(a.MaxPostLatencyP99) {
+		fail("p99 POST /links latency %s > %s", p99, time.Duration(a.MaxPostLatencyP99))
+	}
+	if r.load.addErrors > a.MaxAddErrors {
+		fail("%d links failed to add (> %d)", r.load.addErrors, a.MaxAddErrors)
+	}
+	if a.MaxFetches != nil && r.fetches > *a.MaxFetches {
+		fail("%d title fetches (> %d)", r.fetches, *a.MaxFetches)
+	}
+	return failures
+}
+
+// newRand returns a random number generator seeded with seed (or a random
+// seed, if seed is 0), and the seed.
+func newRand(seed uint64) (*rand.Rand, uint64) {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return rand.New(rand.NewPCG(seed, seed)), seed
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadScenario tests that fields missing from a scenario file default to
+// the flags' values (except faults, which aren't merged), and that validate
+// fills in the defaults of each fault.
+func TestReadScenario(t *testing.T) {
+	defaults := scenarioFromFlags()
+	defaults.Faults = []fault{{Type: "partition"}}
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	body := `{"Servers": 3, "Rate": 100, "Faults": [{"Type": "restart", "AtLink": 5}, {"Type": "partition", "At": "1s", "Groups": [[0]]}]}`
+	if err := os.WriteFile(path, []byte(body), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := readScenario(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Servers != 3 || sc.Rate != 100 {
+		t.Errorf("got %d servers at rate %v, want the file's 3 servers at rate 100", sc.Servers, sc.Rate)
+	}
+	if sc.Links != *numLinks || sc.Topology != *topology || sc.Arrival != *arrival || sc.Concurrency != *concurrency {
+		t.Errorf("got links %d, topology %q, arrival %q and concurrency %d, want the flags' defaults", sc.Links, sc.Topology, sc.Arrival, sc.Concurrency)
+	}
+	want := []fault{
+		{Type: "restart", AtLink: 5, Signal: "TERM", Downtime: duration(*restartDowntime)},
+		{Type: "partition", At: duration(time.Second), Groups: [][]int{{0}}, Duration: duration(*partitionDuration)},
+	}
+	if !reflect.DeepEqual(sc.Faults, want) {
+		t.Errorf("got faults %+v, want %+v", sc.Faults, want)
+	}
+
+	if defaults.Servers != *numServers || len(defaults.Faults) != 1 {
+		t.Errorf("got defaults %+v changed by reading the file", defaults)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"Links": "many"}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readScenario(path, defaults); err == nil {
+		t.Error("reading a bad scenario: got no error, want error")
+	}
+}
+
+// TestScenario_Validate tests that validate rejects scenarios that don't
+// make sense.
+func TestScenario_Validate(t *testing.T) {
+	server5 := 5
+	for _, test := range []struct {
+		name    string
+		change  func(sc *scenario)
+		wantErr string // "" if the scenario is valid
+	}{
+		{"defaults", func(sc *scenario) {}, ""},
+		{"no servers", func(sc *scenario) { sc.Servers = 0 }, "at least 1 server"},
+		{"no links", func(sc *scenario) { sc.Links = 0 }, "at least 1 link"},
+		{"duration", func(sc *scenario) { sc.Links, sc.Duration, sc.Rate = 0, duration(time.Second), 10 }, ""},
+		{"duration without rate", func(sc *scenario) { sc.Duration = duration(time.Second) }, "requires a rate"},
+		{"bad arrival", func(sc *scenario) { sc.Arrival = "bursty" }, "bad arrival"},
+		{"no concurrency", func(sc *scenario) { sc.Concurrency = 0 }, "concurrency"},
+		{"bad topology", func(sc *scenario) { sc.Topology = "tree" }, "bad topology"},
+		{"random without degree", func(sc *scenario) { sc.Topology, sc.Degree = "random", 0 }, "degree"},
+		{"negative rate", func(sc *scenario) { sc.Rate = -1 }, "rate"},
+		{"bad title ratio", func(sc *scenario) { sc.TitleRatio = 1.5 }, "title ratio"},
+		{"bad fetch error rate", func(sc *scenario) { sc.Fetch.ErrorRate = -0.1 }, "fetch error rate"},
+		{"fault after the last link", func(sc *scenario) { sc.Faults = []fault{{Type: "restart", AtLink: sc.Links}} }, "bad time"},
+		{"bad fault type", func(sc *scenario) { sc.Faults = []fault{{Type: "flood"}} }, "bad type"},
+		{"restart of missing server", func(sc *scenario) { sc.Faults = []fault{{Type: "restart", Server: &server5}} }, "no server 5"},
+		{"bad signal", func(sc *scenario) { sc.Faults = []fault{{Type: "restart", Signal: "HUP"}} }, "bad signal"},
+		{"partition of one server", func(sc *scenario) { sc.Servers, sc.Faults = 1, []fault{{Type: "partition"}} }, "at least 2 servers"},
+		{"partition of missing server", func(sc *scenario) { sc.Faults = []fault{{Type: "partition", Groups: [][]int{{0, 5}}}} }, "no server 5"},
+		{"converge without timeout", func(sc *scenario) { sc.Assert.Converge, sc.Assert.ConvergeTimeout = true, 0 }, "timeout"},
+	} {
+		sc := scenarioFromFlags()
+		sc.Servers = 3
+		test.change(sc)
+		err := sc.validate()
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: got error %q, want none", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got error %v, want error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+// TestScenario_Files tests that the example scenarios are valid.
+func TestScenario_Files(t *testing.T) {
+	paths, err := filepath.Glob("scenarios/*.json")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("got scenarios %q, %v, want some", paths, err)
+	}
+	for _, path := range paths {
+		sc, err := readScenario(path, scenarioFromFlags())
+		if err == nil {
+			err = sc.validate()
+		}
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+}
+
+// TestScenario_PeerIndexes tests that each fixed topology peers the servers
+// as documented.
+func TestScenario_PeerIndexes(t *testing.T) {
+	for _, test := range []struct {
+		topology string
+		want     [][]int
+	}{
+		{"mesh", [][]int{{1, 2, 3}, {0, 2, 3}, {0, 1, 3}, {0, 1, 2}}},
+		{"ring", [][]int{{1, 3}, {0, 2}, {1, 3}, {0, 2}}},
+		{"star", [][]int{{1, 2, 3}, {0}, {0}, {0}}},
+	} {
+		sc := &scenario{Servers: 4, Topology: test.topology}
+		if got := sc.peerIndexes(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got peers %v, want %v", test.topology, got, test.want)
+		}
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
 	"time"
 )
@@ -26,34 +28,119 @@ func init() {
 var cmdPath = flag.String("cmd", defaultCmdPath, "path to the gophurls program to test")
 var numServers = flag.Int("servers", 1, "number of gophurls servers to spawn")
 var numLinks = flag.Int("links", 10, "number of links to add")
-var verbose = flag.Bool("v", false, "show verbose output")
+var verbose = flag.Bool("v", false, "show verbose output (pass -server-args=-log-level=debug to see the servers' debug logs too, which trace each link by request ID)")
+var logFormat = flag.String("log-format", "text", "log format (for this program and the servers): text (logfmt) or json")
+var serverArgs = flag.String("server-args", "", "extra (space-separated) command-line arguments for the servers, such as -stream")
+var topology = flag.String("topology", "mesh", "how servers are peered: mesh, ring, star or random")
+var degree = flag.Int("degree", 2, "minimum number of peers of each server (with -topology=random)")
+var rate = flag.Float64("rate", 0, "links arriving per second, which are added regardless of how long earlier links take (0 to add them as fast as possible)")
+var titleRatio = flag.Float64("title-ratio", 0.5, "fraction (0 to 1) of links to add with titles")
+var scenarioFile = flag.String("scenario", "", "JSON file describing the scenario to run (see the scenarios directory); other flags set defaults for fields it omits")
+var printScenario = flag.Bool("print-scenario", false, "print the scenario (as JSON) instead of running it")
 
 type server struct {
-	host string
-	cmd  *exec.Cmd
+	host     string
+	cmd      *exec.Cmd
+	dataFile string    // passed as -data, if not empty
+	args     []string  // extra command-line arguments
+	peers    []*server // set by the scenario's topology
+
+	mu   sync.Mutex
+	down bool // whether the server is being restarted
+}
+
+func (s *server) isDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down
+}
+
+func (s *server) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
 }
 
 var servers []*server
 
+// requestIDHeader is the header that gophurls servers use to trace a link
+// across servers.
+const requestIDHeader = "X-Request-Id"
+
 func main() {
 	flag.Parse()
 
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if *verbose {
+		opts.Level = slog.LevelDebug
+	}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		fatal("Error: -log-format must be text or json.")
+	}
+
+	sc := scenarioFromFlags()
+	if *scenarioFile != "" {
+		var err error
+		if sc, err = readScenario(*scenarioFile, sc); err != nil {
+			fatal("Error reading scenario", "err", err)
+		}
+	}
+	if err := sc.validate(); err != nil {
+		fatal("Error: bad scenario: " + err.Error() + ".")
+	}
+	if *printScenario {
+		b, _ := json.MarshalIndent(sc, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
 	if *cmdPath == "" {
-		log.Fatal("Error: must specify -cmd. Run with -h for instructions.")
+		fatal("Error: must specify -cmd. Run with -h for instructions.")
 	}
-	if *numServers < 1 {
-		log.Fatal("Error: -servers must be at least 1.")
+
+	if *reportFormat != "text" && *reportFormat != "json" {
+		fatal("Error: -report must be text or json.")
+	}
+
+	r := run(sc)
+	rep := newReport(sc, r)
+	rep.AssertionFailures = sc.checkAssertions(r)
+	if err := writeReport(rep, r); err != nil {
+		fatal("Error writing report", "err", err)
 	}
-	if *numLinks < 1 {
-		log.Fatal("Error: -links must be a positive number.")
+	if len(rep.AssertionFailures) > 0 {
+		os.Exit(1)
 	}
+}
+
+// runResult is the outcome of a run, which a scenario's assertions are
+// checked against.
+type runResult struct {
+	seed        uint64
+	elapsed     time.Duration
+	fetches     int // title fetches from the fake server
+	load        *loadResult
+	convergence *convergenceResult // nil if convergence wasn't checked
+}
+
+// run runs the scenario sc: it starts the servers, adds links to them
+// (injecting faults), checks that they converge, and stops them.
+func run(sc *scenario) *runResult {
+	r := &runResult{}
+	rng, r.seed = newRand(sc.Seed)
+	slog.Info("Running scenario", "name", sc.Name, "servers", sc.Servers, "topology", sc.Topology,
+		"links", sc.Links, "duration", time.Duration(sc.Duration), "rate", sc.Rate, "faults", len(sc.Faults), "seed", r.seed)
 
 	// Start servers.
-	err := startServers()
+	err := startServers(sc)
 	if err != nil {
-		log.Fatalf("Error starting servers: %s", err)
+		fatal("Error starting servers", "err", err)
 	}
-	defer killServers()
+	defer stopServers()
 
 	// Start a fake server.
 	var fakeServerRequests struct {
@@ -63,89 +150,78 @@ func main() {
 	fakeMux := http.NewServeMux()
 	fakeMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		title := strings.TrimPrefix(r.URL.Path, "/")
+		slog.Debug("Fake server fetched", "path", r.URL.Path, "request_id", r.Header.Get(requestIDHeader))
 		fmt.Fprintf(w, "<title>fetched-%s</title>", template.HTMLEscapeString(title))
 		fakeServerRequests.mu.Lock()
 		defer fakeServerRequests.mu.Unlock()
 		fakeServerRequests.n++
 	})
-	fakeServer := httptest.NewServer(fakeMux)
+	fakeServer := httptest.NewServer(faultyHandler(fakeMux, time.Duration(sc.Fetch.Latency), sc.Fetch.ErrorRate))
 	defer fakeServer.Close()
 
 	t0 := time.Now()
 
-	// Register servers as each other's peers.
-	for _, s := range servers {
-		// Make a list of all servers except for this one (to avoid self-loops).
-		allPeers := make([]string, len(servers)-1)
-		i := 0
-		for _, s2 := range servers {
-			if s.host == s2.host {
-				// Don't set self as peer.
-				continue
-			}
-			allPeers[i] = s2.host
-			i++
+	// Register servers' peers (through proxies, to be able to partition
+	// them).
+	if sc.hasFault("partition") {
+		if err := startProxies(); err != nil {
+			fatal("Error starting proxies", "err", err)
 		}
-		allPeersJSON, err := json.Marshal(allPeers)
-		if err != nil {
-			log.Fatal(err)
+	}
+	for _, s := range servers {
+		if err := setPeers(s); err != nil {
+			fatal("Error setting peers", "server", s.host, "err", err)
 		}
+	}
 
-		resp, err := http.Post(fmt.Sprintf("http://%s/peers", s.host), "application/json", bytes.NewReader(allPeersJSON))
-		if err != nil {
-			log.Fatalf("Error setting peers for %q: %s", s.host, err)
-		}
-		if resp.StatusCode != http.StatusOK {
-			log.Fatalf("Error setting peers for %q: HTTP status %d", s.host, resp.StatusCode)
-		}
-		resp.Body.Close()
-		if *verbose {
-			log.Printf("Set %d peers for %q.", len(allPeers), s.host)
+	// Add links, injecting faults at their scheduled times.
+	slog.Debug("Adding links", "links", sc.Links, "duration", time.Duration(sc.Duration))
+	conv := newConvergence()
+	stopWatching := make(chan struct{})
+	if sc.Assert.Converge {
+		if ok, err := canListLinks(servers[0].host); err == nil && !ok {
+			slog.Warn("Not checking convergence, because the servers don't list their links as JSON at GET /links")
+			sc.Assert.Converge = false
 		}
 	}
+	if sc.Assert.Converge {
+		go conv.watch(stopWatching)
+	}
+	r.load = addLinks(sc, fakeServer.URL, conv)
 
-	// Add links.
-	if *verbose {
-		log.Printf("Adding %d links...", *numLinks)
+	// Wait for servers to restart and partitions to heal, then check that
+	// every server ended up with every link.
+	faults.Wait()
+	close(stopWatching)
+	if sc.Assert.Converge {
+		r.convergence = conv.check(time.Duration(sc.Assert.ConvergeTimeout))
 	}
-	for i := 0; i < *numLinks; i++ {
-		// Cycle through the servers.
-		s := servers[i%len(servers)]
 
-		// Add a link title for about half of all links.
-		link := &link{
-			URL: fmt.Sprintf("%s/page-%d", fakeServer.URL, i),
-		}
-		if i < *numLinks/2 {
-			link.Title = fmt.Sprintf("unfetched-%d", i)
-		}
+	slog.Debug("Done")
+	stopServers()
+	r.elapsed = time.Since(t0)
+	fakeServerRequests.mu.Lock()
+	r.fetches = fakeServerRequests.n
+	fakeServerRequests.mu.Unlock()
+	return r
+}
 
-		if *verbose {
-			log.Printf("Adding link %v to %q...", link, s.host)
-		}
+// dataDir holds the servers' data files (with -restarts).
+var dataDir string
 
-		err := addLink(s.host, link)
+func startServers(sc *scenario) error {
+	if sc.hasFault("restart") {
+		// Servers keep their links across restarts (if they're stopped
+		// gracefully) in data files.
+		var err error
+		dataDir, err = os.MkdirTemp("", "gophurls-stress-test")
 		if err != nil {
-			log.Printf("Error adding link %v: %s", link, err)
-		}
-
-		if *verbose {
-			log.Printf("Added link %v.", link)
+			return err
 		}
 	}
 
-	if *verbose {
-		log.Printf("Done.")
-	}
-	killServers()
-	time.Sleep(time.Millisecond * 10)
-	fmt.Printf("Total time elapsed: %s\n", time.Since(t0))
-	fmt.Printf("# link fetches: %d\n", fakeServerRequests.n)
-}
-
-func startServers() error {
-	servers = make([]*server, *numServers)
-	for i := 0; i < *numServers; i++ {
+	servers = make([]*server, sc.Servers)
+	for i := 0; i < sc.Servers; i++ {
 		// Find an open port to listen on.
 		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4zero, Port: 0})
 		if err != nil {
@@ -156,32 +232,138 @@ func startServers() error {
 			return err
 		}
 
-		host := l.Addr().String()
-		s := &server{
-			host: host,
-			cmd:  exec.Command(*cmdPath, fmt.Sprintf("-http=%s", host)),
+		s := &server{host: l.Addr().String(), args: sc.ServerArgs}
+		if dataDir != "" {
+			s.dataFile = filepath.Join(dataDir, fmt.Sprintf("server-%d.json", i))
 		}
-		s.cmd.Stdout, s.cmd.Stderr = os.Stdout, os.Stderr
-		err = s.cmd.Start()
-		if err != nil {
+		if err := s.start(); err != nil {
 			return err
 		}
 		servers[i] = s
-		if *verbose {
-			log.Printf("Started server on %s.", s.host)
+		slog.Debug("Started server", "server", s.host)
+	}
+	for i, peers := range sc.peerIndexes() {
+		for _, j := range peers {
+			servers[i].peers = append(servers[i].peers, servers[j])
 		}
 	}
-	time.Sleep(time.Millisecond * 50)
+	return waitReady(readyTimeout)
+}
+
+// start starts the server process.
+func (s *server) start() error {
+	s.cmd = exec.Command(*cmdPath, fmt.Sprintf("-http=%s", s.host))
+	if *logFormat != "text" {
+		// Only pass non-default flags, so that servers that don't have
+		// them (such as part1_app's) can be tested.
+		s.cmd.Args = append(s.cmd.Args, "-log-format="+*logFormat)
+	}
+	if s.dataFile != "" {
+		s.cmd.Args = append(s.cmd.Args, "-data="+s.dataFile)
+	}
+	s.cmd.Args = append(s.cmd.Args, s.args...)
+	s.cmd.Stdout, s.cmd.Stderr = os.Stdout, os.Stderr
+	return s.cmd.Start()
+}
+
+// setPeers registers the server's peers with it.
+func setPeers(s *server) error {
+	var peers []string
+	for _, peer := range s.peers {
+		peers = append(peers, peerAddr(s.host, peer.host))
+	}
+	peersJSON, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(fmt.Sprintf("http://%s/peers", s.host), "application/json", bytes.NewReader(peersJSON))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	slog.Debug("Set peers", "server", s.host, "peers", peers)
 	return nil
 }
 
-func killServers() {
+// readyTimeout is how long to wait for servers to become ready.
+const readyTimeout = 10 * time.Second
+
+// waitReady waits until all servers are ready.
+func waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
 	for _, s := range servers {
-		err := s.cmd.Process.Kill()
-		if err != nil {
-			log.Printf("Failed to kill server process for %q.", s.host)
+		if err := waitServerReady(s, deadline); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// waitServerReady waits until the server responds successfully to GET
+// /readyz. Servers that don't implement /readyz (and respond with 404 Not
+// Found) are considered ready as soon as they respond.
+func waitServerReady(s *server, deadline time.Time) error {
+	for {
+		resp, err := http.Get(fmt.Sprintf("http://%s/readyz", s.host))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server %s not ready by %s", s.host, deadline.Format(time.TimeOnly))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	slog.Debug("Server is ready", "server", s.host)
+	return nil
+}
+
+// stopTimeout is how long to wait for servers to shut down gracefully
+// (finishing their title fetches and broadcasts) before killing them.
+const stopTimeout = 15 * time.Second
+
+// stopServers sends each server SIGTERM, and kills those that haven't exited
+// within stopTimeout.
+func stopServers() {
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		if s == nil || s.cmd.Process == nil {
+			continue // not started
+		}
+		if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			slog.Error("Failed to signal server process", "server", s.host, "err", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan error, 1)
+			go func() { done <- s.cmd.Wait() }()
+			select {
+			case err := <-done:
+				if err != nil {
+					slog.Error("Server exited with error", "server", s.host, "err", err)
+				} else {
+					slog.Debug("Server stopped", "server", s.host)
+				}
+			case <-time.After(stopTimeout):
+				slog.Error("Server didn't stop in time; killing it", "server", s.host, "timeout", stopTimeout)
+				if err := s.cmd.Process.Kill(); err != nil {
+					slog.Error("Failed to kill server process", "server", s.host, "err", err)
+				}
+				<-done
+			}
+		}()
+	}
+	wg.Wait()
+	servers = nil
+	if dataDir != "" {
+		os.RemoveAll(dataDir)
+	}
 }
 
 type link struct {
@@ -189,12 +371,18 @@ type link struct {
 	Title string `json:",omitempty"`
 }
 
-func addLink(host string, link *link) error {
+func addLink(host string, link *link, requestID string) error {
 	linkJSON, err := json.Marshal(link)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(fmt.Sprintf("http://%s/links", host), "application/json", bytes.NewReader(linkJSON))
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/links", host), bytes.NewReader(linkJSON))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(requestIDHeader, requestID)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -204,3 +392,10 @@ func addLink(host string, link *link) error {
 	}
 	return nil
 }
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	stopServers()
+	os.Exit(1)
+}
@@ -0,0 +1,10 @@
+// Package crdt implements the conflict-free replicated data types used to
+// replicate links between GophURLs servers.
+//
+// The link collection is an observed-remove set (Set) whose elements carry
+// last-writer-wins registers (Register) for their mutable fields, ordered by
+// hybrid logical clock timestamps (Clock). All replicated state is merged
+// with operations that are commutative, associative and idempotent, so
+// servers converge to the same state regardless of the order in which they
+// receive updates, or whether they receive an update more than once.
+package crdt
@@ -0,0 +1,103 @@
+package crdt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock timestamp. Timestamps generated by
+// different Clocks never compare equal, because Node breaks ties.
+//
+// The zero Timestamp sorts before all others. It is used for state that
+// every server can create independently and identically, such as the
+// initial addition of a link (see Set.Add).
+type Timestamp struct {
+	// Wall is the physical time component, in nanoseconds since the Unix
+	// epoch.
+	Wall int64 `json:",omitempty"`
+
+	// Logical orders timestamps with the same Wall.
+	Logical uint32 `json:",omitempty"`
+
+	// Node identifies the clock that generated the timestamp.
+	Node string `json:",omitempty"`
+}
+
+// IsZero reports whether t is the zero Timestamp.
+func (t Timestamp) IsZero() bool { return t == Timestamp{} }
+
+// Compare returns -1, 0 or +1 depending on whether t is before, equal to or
+// after u.
+func (t Timestamp) Compare(u Timestamp) int {
+	switch {
+	case t.Wall != u.Wall:
+		return cmp(t.Wall < u.Wall)
+	case t.Logical != u.Logical:
+		return cmp(t.Logical < u.Logical)
+	case t.Node != u.Node:
+		return cmp(t.Node < u.Node)
+	}
+	return 0
+}
+
+// Less reports whether t is before u.
+func (t Timestamp) Less(u Timestamp) bool { return t.Compare(u) < 0 }
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d@%s", t.Wall, t.Logical, t.Node)
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Clock is a hybrid logical clock. Its timestamps stay close to physical
+// time but are always greater than every timestamp the clock has generated
+// or observed, so causally related updates are ordered correctly even when
+// servers' physical clocks are skewed.
+type Clock struct {
+	node     string
+	physical func() time.Time
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+// NewClock returns a clock whose timestamps are tagged with node, which must
+// be unique among all servers. If physical is nil, time.Now is used.
+func NewClock(node string, physical func() time.Time) *Clock {
+	if physical == nil {
+		physical = time.Now
+	}
+	return &Clock{node: node, physical: physical}
+}
+
+// Node returns the node ID that c tags its timestamps with.
+func (c *Clock) Node() string { return c.node }
+
+// Now returns a new timestamp that is greater than all timestamps previously
+// returned by or passed to c.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.physical().UnixNano(); pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+	return c.last
+}
+
+// Update advances c past t, which was received from another server.
+func (c *Clock) Update(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Wall > c.last.Wall || (t.Wall == c.last.Wall && t.Logical > c.last.Logical) {
+		c.last.Wall, c.last.Logical = t.Wall, t.Logical
+	}
+}
@@ -0,0 +1,17 @@
+package crdt
+
+// Link is the value of a replicated link, for use in a Set keyed by the
+// link's URL. Each mutable field is a separate register so that concurrent
+// edits of different fields are both kept.
+type Link struct {
+	Title Register[string]   `json:",omitzero"`
+	Tags  Register[[]string] `json:",omitzero"`
+}
+
+// Merge implements Value.
+func (l Link) Merge(m Link) Link {
+	return Link{
+		Title: l.Title.Merge(m.Title),
+		Tags:  l.Tags.Merge(m.Tags),
+	}
+}
@@ -0,0 +1,184 @@
+package crdt
+
+import (
+	"sort"
+	"sync"
+)
+
+// Value is the type of the values of a Set's elements. Merge must be
+// commutative, associative and idempotent, which is the case for any struct
+// of Registers merged field by field.
+type Value[V any] interface {
+	Merge(V) V
+}
+
+// Entry is the replicated state of one element of a Set. Entries are also
+// the unit of replication: sending an element's Entry to another server and
+// merging it there with Set.Merge brings that server up to date on the
+// element.
+type Entry[V Value[V]] struct {
+	// Adds holds the tags of all additions of the element, sorted.
+	Adds []Timestamp `json:",omitempty"`
+
+	// Removes holds the tags of all additions that have been observed to be
+	// removed, sorted. Removed tags are kept as tombstones so that merging a
+	// stale copy of the element can't resurrect it.
+	Removes []Timestamp `json:",omitempty"`
+
+	// Value is the element's value, which is retained (and may still be
+	// updated) even while the element is removed.
+	Value V
+}
+
+// Present reports whether the element is in the set, which is when some
+// addition of it has not been removed.
+func (e Entry[V]) Present() bool {
+	for _, t := range e.Adds {
+		if !containsTag(e.Removes, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns the union of e and f.
+func (e Entry[V]) Merge(f Entry[V]) Entry[V] {
+	return Entry[V]{
+		Adds:    unionTags(e.Adds, f.Adds),
+		Removes: unionTags(e.Removes, f.Removes),
+		Value:   e.Value.Merge(f.Value),
+	}
+}
+
+func containsTag(tags []Timestamp, t Timestamp) bool {
+	i := sort.Search(len(tags), func(i int) bool { return !tags[i].Less(t) })
+	return i < len(tags) && tags[i] == t
+}
+
+func unionTags(a, b []Timestamp) []Timestamp {
+	var u []Timestamp
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].Less(b[0])):
+			u, a = append(u, a[0]), a[1:]
+		case len(a) == 0 || b[0].Less(a[0]):
+			u, b = append(u, b[0]), b[1:]
+		default:
+			u, a, b = append(u, a[0]), a[1:], b[1:]
+		}
+	}
+	return u
+}
+
+// Set is an observed-remove set (OR-Set) of elements with values of type V,
+// keyed by string. When an addition and a removal of the same element are
+// concurrent, the addition wins. It is safe for concurrent use.
+type Set[V Value[V]] struct {
+	mu      sync.Mutex
+	entries map[string]Entry[V]
+	keys    []string // in the order they were first seen
+}
+
+// NewSet returns an empty set.
+func NewSet[V Value[V]]() *Set[V] {
+	return &Set[V]{entries: make(map[string]Entry[V])}
+}
+
+// Add adds the element with the given key, merging v into its value. The tag
+// must be unique to this addition, such as a fresh timestamp from a Clock.
+// The zero tag denotes an addition that all servers may perform
+// independently (and that a removal therefore removes everywhere). It
+// returns the element's new Entry.
+func (s *Set[V]) Add(key string, tag Timestamp, v V) Entry[V] {
+	_, e, _ := s.Merge(key, Entry[V]{Adds: []Timestamp{tag}, Value: v})
+	return e
+}
+
+// Remove removes the element with the given key by tombstoning all of its
+// additions observed so far. It returns the element's new Entry, and false
+// if the element was not present.
+func (s *Set[V]) Remove(key string) (Entry[V], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !e.Present() {
+		return e, false
+	}
+	e.Removes = unionTags(e.Removes, e.Adds)
+	s.entries[key] = e
+	return e, true
+}
+
+// Update merges v into the value of the element with the given key, which
+// need not be present. It returns the element's new Entry.
+func (s *Set[V]) Update(key string, v V) Entry[V] {
+	_, e, _ := s.Merge(key, Entry[V]{Value: v})
+	return e
+}
+
+// Merge merges e, received from another server, into the element with the
+// given key. It returns the element's Entry before and after the merge (read
+// and written together, so that callers can tell what the merge changed),
+// and whether the merge changed it.
+func (s *Set[V]) Merge(key string, e Entry[V]) (old, merged Entry[V], changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.merge(key, e)
+}
+
+// MergeResult is the result of one merge by MergeAll, as returned by Merge.
+type MergeResult[V Value[V]] struct {
+	Old, Merged Entry[V]
+	Changed     bool
+}
+
+// MergeAll merges es[i] into the element with key keys[i], for each i, all
+// at once: other operations on s see either none of the merges or all of
+// them. It returns the result of each merge.
+func (s *Set[V]) MergeAll(keys []string, es []Entry[V]) []MergeResult[V] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]MergeResult[V], len(keys))
+	for i, key := range keys {
+		r := &results[i]
+		r.Old, r.Merged, r.Changed = s.merge(key, es[i])
+	}
+	return results
+}
+
+// merge merges e into the element with the given key. s.mu must be held.
+func (s *Set[V]) merge(key string, e Entry[V]) (old, merged Entry[V], changed bool) {
+	old, ok := s.entries[key]
+	if !ok {
+		s.keys = append(s.keys, key)
+	}
+	merged = old.Merge(e)
+	s.entries[key] = merged
+	return old, merged, !ok || !equalEntries(old, merged)
+}
+
+func equalEntries[V Value[V]](a, b Entry[V]) bool {
+	if len(a.Adds) != len(b.Adds) || len(a.Removes) != len(b.Removes) {
+		return false
+	}
+	// This is only used to compare an entry with the result of merging into
+	// it, and merging never drops tags, so equal lengths means equal tags.
+	return equalJSON(a.Value, b.Value)
+}
+
+// Get returns the Entry for the element with the given key, and false if
+// the set has never seen the element.
+func (s *Set[V]) Get(key string) (Entry[V], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	return e, ok
+}
+
+// Keys returns the keys of all elements the set has seen (including removed
+// elements), in the order they were first seen.
+func (s *Set[V]) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...)
+}
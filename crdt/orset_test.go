@@ -0,0 +1,222 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// delta is an update made on one simulated peer, to be delivered to the
+// others.
+type delta struct {
+	key   string
+	entry Entry[Link]
+}
+
+// peer is a simulated server holding a replica of a link set.
+type peer struct {
+	clock *Clock
+	set   *Set[Link]
+}
+
+// simulate runs a random sequence of operations on n simulated peers,
+// delivering each resulting delta to every other peer in a random order
+// (with some deltas delivered more than once), and returns the peers.
+func simulate(seed int64, n, numOps int) []*peer {
+	rnd := rand.New(rand.NewSource(seed))
+	var physical int64
+	now := func() time.Time {
+		// Skewed, sometimes non-monotonic physical clocks.
+		physical += int64(rnd.Intn(3)) - 1
+		return time.Unix(0, physical)
+	}
+
+	peers := make([]*peer, n)
+	for i := range peers {
+		peers[i] = &peer{clock: NewClock(fmt.Sprintf("node%d", i), now), set: NewSet[Link]()}
+	}
+	urls := []string{"http://a.com", "http://b.com", "http://c.com"}
+
+	// inbox[i] holds the deltas not yet delivered to peer i.
+	inbox := make([][]delta, n)
+	deliver := func(i int) {
+		j := rnd.Intn(len(inbox[i]))
+		d := inbox[i][j]
+		if rnd.Intn(4) != 0 { // sometimes leave it to be delivered again
+			inbox[i] = append(inbox[i][:j], inbox[i][j+1:]...)
+		}
+		for _, t := range d.entry.Adds {
+			peers[i].clock.Update(t)
+		}
+		peers[i].clock.Update(d.entry.Value.Title.Time)
+		peers[i].clock.Update(d.entry.Value.Tags.Time)
+		peers[i].set.Merge(d.key, d.entry)
+	}
+
+	for op := 0; op < numOps; op++ {
+		i := rnd.Intn(n)
+		p := peers[i]
+		if len(inbox[i]) > 0 && rnd.Intn(2) == 0 {
+			deliver(i)
+			continue
+		}
+
+		key := urls[rnd.Intn(len(urls))]
+		var e Entry[Link]
+		switch rnd.Intn(5) {
+		case 0:
+			e = p.set.Add(key, Timestamp{}, Link{Title: Register[string]{Value: "initial"}})
+		case 1:
+			e = p.set.Add(key, p.clock.Now(), Link{})
+		case 2:
+			var ok bool
+			if e, ok = p.set.Remove(key); !ok {
+				continue
+			}
+		case 3:
+			e = p.set.Update(key, Link{Title: Register[string]{}.Set(fmt.Sprintf("title%d", op), p.clock.Now())})
+		case 4:
+			e = p.set.Update(key, Link{Tags: Register[[]string]{}.Set([]string{fmt.Sprint(op)}, p.clock.Now())})
+		}
+		for j := range inbox {
+			if j != i {
+				inbox[j] = append(inbox[j], delta{key, e})
+			}
+		}
+	}
+
+	// Deliver everything that's left.
+	for i := range inbox {
+		for len(inbox[i]) > 0 {
+			deliver(i)
+		}
+	}
+	return peers
+}
+
+func snapshot(s *Set[Link]) string {
+	m := make(map[string]Entry[Link])
+	for _, k := range s.Keys() {
+		m[k], _ = s.Get(k)
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// TestSet_Converges tests that simulated peers converge to the same state
+// no matter the order in which they receive each other's updates.
+func TestSet_Converges(t *testing.T) {
+	converges := func(seed int64) bool {
+		peers := simulate(seed, 4, 200)
+		want := snapshot(peers[0].set)
+		for i, p := range peers[1:] {
+			if got := snapshot(p.set); got != want {
+				t.Logf("seed %d: peer %d state\n%s\ndiffers from peer 0 state\n%s", seed, i+1, got, want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(converges, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSet_RemoveZeroTag tests that re-adding an element with the zero tag
+// after it has been removed does not resurrect it, but adding it with a new
+// tag does.
+func TestSet_RemoveZeroTag(t *testing.T) {
+	c := NewClock("node", nil)
+	s := NewSet[Link]()
+	s.Add("k", Timestamp{}, Link{})
+	if _, ok := s.Remove("k"); !ok {
+		t.Fatal("Remove: element was not present")
+	}
+	if e := s.Add("k", Timestamp{}, Link{}); e.Present() {
+		t.Error("element re-added with zero tag is present, want not present")
+	}
+	if e := s.Add("k", c.Now(), Link{}); !e.Present() {
+		t.Error("element re-added with new tag is not present, want present")
+	}
+}
+
+// TestSet_AddWins tests that when an addition and a removal are concurrent,
+// the addition wins.
+func TestSet_AddWins(t *testing.T) {
+	a, b := NewSet[Link](), NewSet[Link]()
+	ca := NewClock("a", nil)
+	e := a.Add("k", ca.Now(), Link{})
+	b.Merge("k", e)
+
+	removed, _ := a.Remove("k")
+	added := b.Add("k", NewClock("b", nil).Now(), Link{})
+	a.Merge("k", added)
+	b.Merge("k", removed)
+
+	for _, s := range []*Set[Link]{a, b} {
+		if e, _ := s.Get("k"); !e.Present() {
+			t.Errorf("got entry %+v, want present", e)
+		}
+	}
+}
+
+// TestSet_MergeOld tests that Merge returns the element's entry from before
+// the merge, even when other merges are concurrent, so that every change is
+// seen by exactly one merge.
+func TestSet_MergeOld(t *testing.T) {
+	s := NewSet[Link]()
+	c := NewClock("a", nil)
+	tags := make([]Timestamp, 100)
+	for i := range tags {
+		tags[i] = c.Now()
+	}
+
+	var wg sync.WaitGroup
+	added := make(chan int, len(tags))
+	for _, tag := range tags {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			old, merged, changed := s.Merge("k", Entry[Link]{Adds: []Timestamp{tag}})
+			if containsTag(old.Adds, tag) || !containsTag(merged.Adds, tag) || !changed {
+				t.Errorf("Merge of tag %v: got old %+v, merged %+v, changed %v", tag, old, merged, changed)
+			}
+			added <- len(merged.Adds) - len(old.Adds)
+		}()
+	}
+	wg.Wait()
+	close(added)
+	for n := range added {
+		if n != 1 {
+			t.Errorf("merge added %d tags, want 1 (old entry was not read with the merge)", n)
+		}
+	}
+}
+
+// TestClock_Monotonic tests that a clock's timestamps always increase, even
+// if physical time goes backwards.
+func TestClock_Monotonic(t *testing.T) {
+	physical := []int64{10, 5, 5, 20, 1}
+	c := NewClock("node", func() time.Time {
+		pt := physical[0]
+		physical = physical[1:]
+		return time.Unix(0, pt)
+	})
+	var last Timestamp
+	for i := 0; i < 4; i++ {
+		ts := c.Now()
+		if !last.Less(ts) {
+			t.Errorf("got timestamp %v after %v, want greater", ts, last)
+		}
+		last = ts
+	}
+
+	c.Update(Timestamp{Wall: 100, Logical: 3, Node: "other"})
+	if ts := c.Now(); !(Timestamp{Wall: 100, Logical: 3, Node: "other"}).Less(ts) {
+		t.Errorf("got timestamp %v after observing 100.3, want greater", ts)
+	}
+}
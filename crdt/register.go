@@ -0,0 +1,56 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// RegisterValue is the type of value a Register holds. Both are empty
+// when zero, so Merge can tell a set value from a zero one with len.
+type RegisterValue interface {
+	~string | ~[]string
+}
+
+// Register is a last-writer-wins register: merging two registers keeps the
+// value with the later timestamp.
+type Register[T RegisterValue] struct {
+	Value T         `json:",omitempty"`
+	Time  Timestamp `json:",omitzero"`
+}
+
+// Set returns a register holding v, written at time t.
+func (r Register[T]) Set(v T, t Timestamp) Register[T] {
+	return Register[T]{Value: v, Time: t}
+}
+
+// Merge returns the later of r and s. If both were written at the same time
+// (which only happens for zero timestamps), a non-empty value wins over an
+// empty one, and otherwise the value with the greater JSON encoding wins,
+// so that every server picks the same one.
+func (r Register[T]) Merge(s Register[T]) Register[T] {
+	switch r.Time.Compare(s.Time) {
+	case -1:
+		return s
+	case 1:
+		return r
+	}
+	if rz, sz := len(r.Value) == 0, len(s.Value) == 0; rz != sz {
+		if rz {
+			return s
+		}
+		return r
+	}
+	rj, _ := json.Marshal(r.Value)
+	sj, _ := json.Marshal(s.Value)
+	if bytes.Compare(sj, rj) > 0 {
+		return s
+	}
+	return r
+}
+
+// equalJSON reports whether a and b have the same JSON encoding.
+func equalJSON(a, b any) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return bytes.Equal(aj, bj)
+}
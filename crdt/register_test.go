@@ -0,0 +1,38 @@
+package crdt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestRegisterMerge_Zero tests that, at the same (zero) timestamp, a set
+// register wins over a zero-valued one whichever is merged into which, so
+// that editing only a link's title doesn't lose its tags, and that a later
+// zero value still wins over an earlier set one.
+func TestRegisterMerge_Zero(t *testing.T) {
+	title := Register[string]{Value: "Title"}
+	if got := (Register[string]{}).Merge(title); got != title {
+		t.Errorf("zero.Merge(set): got %+v, want %+v", got, title)
+	}
+	if got := title.Merge(Register[string]{}); got != title {
+		t.Errorf("set.Merge(zero): got %+v, want %+v", got, title)
+	}
+
+	tags := Register[[]string]{Value: []string{"go"}}
+	for _, zero := range []Register[[]string]{{}, {Value: []string{}}} {
+		if got := zero.Merge(tags); !reflect.DeepEqual(got, tags) {
+			t.Errorf("%+v.Merge(set): got %+v, want %+v", zero, got, tags)
+		}
+		if got := tags.Merge(zero); !reflect.DeepEqual(got, tags) {
+			t.Errorf("set.Merge(%+v): got %+v, want %+v", zero, got, tags)
+		}
+	}
+
+	clock := NewClock("a", func() time.Time { return time.Unix(1, 0) })
+	set := Register[string]{}.Set("Title", clock.Now())
+	cleared := Register[string]{}.Set("", clock.Now())
+	if got := set.Merge(cleared); got != cleared {
+		t.Errorf("got %+v, want later zero value %+v to win", got, cleared)
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var httpAddr = flag.String("http", ":7000", "HTTP service address")
@@ -16,7 +21,23 @@ func init() {
 
 func main() {
 	flag.Parse()
-	if err := http.ListenAndServe(*httpAddr, nil); err != nil {
+	srv := &http.Server{Addr: *httpAddr}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	// Shut down gracefully (letting in-flight requests finish) on SIGTERM or
+	// SIGINT.
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-sigc:
+	}
+	signal.Stop(sigc)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
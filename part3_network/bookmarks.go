@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/sourcegraph/gophurls/server"
+)
+
+// exportCmd implements the "export" subcommand, which writes all links on a
+// running server to a file (or stdout).
+func exportCmd(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	addr := fs.String("server", "localhost:7000", "host:port of the server to export links from")
+	format := fs.String("format", "", "bookmark format: html, jsonl or csv (default: from file extension, or jsonl)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gophurls export [flags] [file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if name := fs.Arg(0); name != "" {
+		if *format == "" {
+			*format = server.FormatFromName(name)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			fatal("Error creating export file", "err", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if *format == "" {
+		*format = server.FormatJSONL
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/export?format=%s", *addr, url.QueryEscape(*format)))
+	if err != nil {
+		fatal("Error exporting links", "err", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fatal("Error exporting links", "status", resp.StatusCode)
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fatal("Error exporting links", "err", err)
+	}
+}
+
+// importCmd implements the "import" subcommand, which adds the links in
+// bookmark files to a running server.
+func importCmd(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	addr := fs.String("server", "localhost:7000", "host:port of the server to import links into")
+	format := fs.String("format", "", "bookmark format: html, jsonl or csv (default: from file extension)")
+	share := fs.Bool("broadcast", false, "broadcast imported links to the server's peers")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gophurls import [flags] file...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("Error opening bookmarks file", "err", err)
+		}
+		fileFormat := *format
+		if fileFormat == "" {
+			fileFormat = server.FormatFromName(name)
+		}
+		u := fmt.Sprintf("http://%s/import?format=%s&broadcast=%t", *addr, url.QueryEscape(fileFormat), *share)
+		req, err := http.NewRequest("POST", u, f)
+		if err != nil {
+			fatal("Error importing links", "file", name, "err", err)
+		}
+		req.Header.Set("content-type", server.ContentType(fileFormat))
+		if *adminToken != "" {
+			req.Header.Set("Authorization", "Bearer "+*adminToken)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		f.Close()
+		if err != nil {
+			fatal("Error importing links", "file", name, "err", err)
+		}
+		var res server.ImportResult
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			fatal("Error importing links", "file", name, "status", resp.StatusCode)
+		}
+		fmt.Printf("%s: %d imported, %d duplicates, %d fetching titles\n", name, res.Imported, res.Duplicates, res.Fetching)
+		for _, e := range res.Errors {
+			fmt.Printf("%s: skipped %s\n", name, e)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// configKeys maps each setting in the config file to the flag that it sets.
+// Each can also be set with an environment variable named GOPHURLS_
+// followed by the key in upper case, with dots replaced by underscores (for
+// example, GOPHURLS_FETCH_MAX_CONCURRENT). Flags given on the command line
+// take precedence over environment variables, which take precedence over the
+// config file.
+var configKeys = map[string]string{
+	"http":                 "http",
+	"grpc":                 "grpc",
+	"data":                 "data",
+	"peers":                "peers",
+	"stream":               "stream",
+	"shutdown_timeout":     "shutdown-timeout",
+	"fetch.max_concurrent": "max-fetches",
+	"fetch.timeout":        "fetch-timeout",
+	"auth.admin_token":     "admin-token",
+	"log.format":           "log-format",
+	"log.level":            "log-level",
+	"trace.exporter":       "trace",
+	"trace.otlp_endpoint":  "otlp-endpoint",
+}
+
+// reloadableKeys are the settings that are applied when the config is
+// reloaded on SIGHUP. Changes to others take effect at the next restart.
+var reloadableKeys = []string{"peers", "fetch.max_concurrent", "fetch.timeout"}
+
+// envName returns the name of the environment variable that overrides the
+// setting key.
+func envName(key string) string {
+	return "GOPHURLS_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// loadConfig sets the flags in fs (other than those in cmdline, which were
+// given on the command line) from the config file at path (if not empty)
+// and then from environment variables (looked up with getenv). Flags that
+// neither sets are reset to their defaults, so that loadConfig can be called
+// again to reload the config.
+func loadConfig(fs *flag.FlagSet, cmdline map[string]bool, path string, getenv func(string) (string, bool)) error {
+	settings := make(map[string]string)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		settings, err = parseConfig(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	source := make(map[string]string) // where each setting came from
+	for key := range settings {
+		source[key] = fmt.Sprintf("%s in %s", key, path)
+	}
+	for key := range configKeys {
+		if v, ok := getenv(envName(key)); ok {
+			settings[key], source[key] = v, envName(key)
+		}
+	}
+
+	for key, name := range configKeys {
+		if cmdline[name] {
+			continue
+		}
+		f := fs.Lookup(name)
+		v, ok := settings[key]
+		if !ok {
+			v = f.DefValue
+		}
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("bad %s: %s", source[key], err)
+		}
+	}
+	return nil
+}
+
+// configLine matches a "key = value" line of the config file.
+var configLine = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+
+// parseConfig parses a config file, which is in a subset of TOML: "key =
+// value" lines, grouped into "[table]"s, with string, integer, boolean and
+// (single-line) string array values. It returns the value of each setting
+// (keyed by "table.key") as a flag value: arrays are joined with commas.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	settings := make(map[string]string)
+	var table string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(stripComment(line), "]")
+			if !ok {
+				return nil, fmt.Errorf("line %d: bad table header %q", n, line)
+			}
+			table = strings.TrimSpace(name[1:])
+			continue
+		}
+		m := configLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: want key = value, got %q", n, line)
+		}
+		key := m[1]
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, known := configKeys[key]; !known {
+			return nil, fmt.Errorf("line %d: unknown setting %q", n, key)
+		}
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("line %d: %q is set twice", n, key)
+		}
+		v, err := parseValue(stripComment(m[2]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %s", n, key, err)
+		}
+		settings[key] = v
+	}
+	return settings, scanner.Err()
+}
+
+// stripComment removes a trailing "# comment" (outside of strings) from s.
+func stripComment(s string) string {
+	var quote rune
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// parseValue parses a TOML value: a string, an integer, a boolean or an
+// array of strings.
+func parseValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", errors.New("missing value")
+	case s[0] == '"':
+		return strconv.Unquote(s)
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.Contains(s[1:len(s)-1], "'") {
+			return "", fmt.Errorf("bad string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return "", fmt.Errorf("bad array %s (arrays must be on one line)", s)
+		}
+		var elems []string
+		for _, e := range splitArray(s[1 : len(s)-1]) {
+			v, err := parseValue(e)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(v, ",") {
+				return "", fmt.Errorf("array element %s must not contain a comma", e)
+			}
+			elems = append(elems, v)
+		}
+		return strings.Join(elems, ","), nil
+	case s == "true" || s == "false":
+		return s, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 0, 64); err != nil {
+		return "", fmt.Errorf("bad value %s (strings must be quoted)", s)
+	}
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
+// splitArray splits the elements of an array (without its brackets) at
+// commas outside of strings, allowing a trailing comma.
+func splitArray(s string) []string {
+	var elems []string
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote && (quote == '\'' || i == 0 || s[i-1] != '\\') {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			elems = append(elems, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		elems = append(elems, last)
+	}
+	return elems
+}
+
+// splitPeers splits the comma-separated -peers flag into hosts.
+func splitPeers(s string) []string {
+	var hosts []string
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/gophurls/server"
+)
+
+// TestParseConfig tests parsing each kind of value in a config file.
+func TestParseConfig(t *testing.T) {
+	settings, err := parseConfig(strings.NewReader(`
+# Listen addresses.
+http = ":8000" # HTTP
+grpc = ':9000'
+peers = ["a.example.com:7000", 'b.example.com:7000',]
+stream = true
+
+[fetch]
+max_concurrent = 1_000
+timeout = "5s"
+
+[auth]
+admin_token = "s#cret \"quoted\""
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"http":                 ":8000",
+		"grpc":                 ":9000",
+		"peers":                "a.example.com:7000,b.example.com:7000",
+		"stream":               "true",
+		"fetch.max_concurrent": "1000",
+		"fetch.timeout":        "5s",
+		"auth.admin_token":     `s#cret "quoted"`,
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("got settings %v, want %v", settings, want)
+	}
+}
+
+// TestParseConfig_Errors tests that bad config files are rejected with the
+// line of the error.
+func TestParseConfig_Errors(t *testing.T) {
+	for config, want := range map[string]string{
+		"htp = \":8000\"":                 `line 1: unknown setting "htp"`,
+		"\n[fetch]\ntimeout = 5s":         "line 3: fetch.timeout: bad value 5s",
+		"http = \":1\"\nhttp = \":2\"":    `line 2: "http" is set twice`,
+		"peers = [\"a:1\",\n\"b:1\"]":     "line 1: peers: bad array",
+		"[fetch\nmax_concurrent = 1":      "line 1: bad table header",
+		"http":                            "line 1: want key = value",
+		"data = \"unterminated":           "line 1: data: invalid syntax",
+		"peers = [\"a:1,b:1\"]":           "must not contain a comma",
+		"[log]\nformat = 'it's'":          "line 2: log.format: bad string",
+		"[trace]\nexporter =  # no value": "line 2: trace.exporter: missing value",
+	} {
+		_, err := parseConfig(strings.NewReader(config))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", config, err, want)
+		}
+	}
+}
+
+// TestLoadConfig tests that flags given on the command line take precedence
+// over environment variables, which take precedence over the config file,
+// and that reloading resets settings removed from the config file.
+func TestLoadConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for key, name := range configKeys {
+		switch key {
+		case "fetch.max_concurrent":
+			fs.Int(name, 10, "")
+		case "fetch.timeout", "shutdown_timeout":
+			fs.Duration(name, 0, "")
+		case "stream":
+			fs.Bool(name, false, "")
+		default:
+			fs.String(name, "", "")
+		}
+	}
+	if err := fs.Parse([]string{"-data", "cmdline.json"}); err != nil {
+		t.Fatal(err)
+	}
+	cmdline := map[string]bool{"data": true}
+
+	path := filepath.Join(t.TempDir(), "gophurls.toml")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`
+http = ":8000"
+data = "config.json"
+peers = ["a.example.com:7000"]
+[fetch]
+max_concurrent = 3
+timeout = "5s"
+`)
+	env := map[string]string{"GOPHURLS_FETCH_MAX_CONCURRENT": "4"}
+	getenv := func(name string) (string, bool) { v, ok := env[name]; return v, ok }
+	if err := loadConfig(fs, cmdline, path, getenv); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name, want string) {
+		t.Helper()
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("got -%s=%s, want %s", name, got, want)
+		}
+	}
+	check("http", ":8000")
+	check("data", "cmdline.json")
+	check("peers", "a.example.com:7000")
+	check("max-fetches", "4")
+	check("fetch-timeout", (5 * time.Second).String())
+
+	writeConfig(`peers = ["b.example.com:7000"]`)
+	if err := loadConfig(fs, cmdline, path, getenv); err != nil {
+		t.Fatal(err)
+	}
+	check("http", "")
+	check("peers", "b.example.com:7000")
+	check("fetch-timeout", "0s")
+
+	env["GOPHURLS_FETCH_TIMEOUT"] = "soon"
+	if err := loadConfig(fs, cmdline, path, getenv); err == nil || !strings.Contains(err.Error(), "bad GOPHURLS_FETCH_TIMEOUT") {
+		t.Errorf("got error %v, want bad GOPHURLS_FETCH_TIMEOUT", err)
+	}
+}
+
+// TestExampleConfig tests that the example config file parses.
+func TestExampleConfig(t *testing.T) {
+	f, err := os.Open("gophurls.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := parseConfig(f); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReload tests that reloading applies changes to the peers and fetch
+// limits, but keeps settings that can't be reloaded as they were.
+func TestReload(t *testing.T) {
+	t.Cleanup(func() {
+		for _, name := range configKeys {
+			flag.Set(name, flag.Lookup(name).DefValue)
+		}
+	})
+	s := server.New(server.Options{})
+	t.Setenv("GOPHURLS_PEERS", "a.example.com:7000")
+	t.Setenv("GOPHURLS_FETCH_MAX_CONCURRENT", "3")
+	t.Setenv("GOPHURLS_SHUTDOWN_TIMEOUT", "1s")
+	if err := reload(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Peers(), []string{"a.example.com:7000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got peers %v, want %v", got, want)
+	}
+	if *maxFetches != 3 {
+		t.Errorf("got -max-fetches=%d, want 3", *maxFetches)
+	}
+	if *shutdownTimeout != 10*time.Second {
+		t.Errorf("got -shutdown-timeout=%s, want it unchanged until restart", *shutdownTimeout)
+	}
+}
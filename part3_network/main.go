@@ -0,0 +1,175 @@
+// Command part3_network runs a GophURLs server (see package server) that
+// shares links with its peers.
+//
+// Its settings can also be given in a config file (see -config, and
+// configKeys for the settings) and in GOPHURLS_* environment variables.
+// On SIGHUP, it reloads the config file and environment and applies changes
+// to the peers and fetch limits.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/sourcegraph/gophurls/server"
+)
+
+var (
+	httpAddr        = flag.String("http", ":7000", "HTTP service address")
+	grpcAddr        = flag.String("grpc", "", "gRPC service address (disabled if empty)")
+	dataFile        = flag.String("data", "", "file to load links from at startup, and to save links (and undelivered broadcasts and unfinished title fetches) to at shutdown")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for title fetches and broadcasts to finish when shutting down")
+	streamPeers     = flag.Bool("stream", false, "send links to each peer over one persistent stream (falling back to POST /links for peers that don't support it)")
+	logFormat       = flag.String("log-format", "text", "log format: text (logfmt) or json")
+	logLevel        = flag.String("log-level", "info", "minimum level of messages to log: debug, info, warn or error")
+	traceExporter   = flag.String("trace", "", "export OpenTelemetry trace spans to: otlp, stdout, or nowhere (if empty)")
+	otlpEndpoint    = flag.String("otlp-endpoint", "http://localhost:4318/v1/traces", "URL of the OTLP/HTTP traces endpoint (with -trace=otlp)")
+	configFile      = flag.String("config", os.Getenv("GOPHURLS_CONFIG"), "TOML config file to read settings from (flags given on the command line and GOPHURLS_* environment variables override it)")
+	peersFlag       = flag.String("peers", "", "comma-separated list of initial peers (in host:port format)")
+	maxFetches      = flag.Int("max-fetches", 10, "maximum number of title fetches that may run at once")
+	fetchTimeout    = flag.Duration("fetch-timeout", 0, "how long a title fetch may take (0 for no limit)")
+	adminToken      = flag.String("admin-token", "", "if set, the bearer token that requests to add peers, import links, edit or delete links or see /admin/status must carry")
+)
+
+func main() {
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	if err := loadConfig(flag.CommandLine, cmdlineFlags, *configFile, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := server.StartTracing(*traceExporter, *otlpEndpoint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	switch flag.Arg(0) {
+	case "export":
+		exportCmd(flag.Args()[1:])
+		return
+	case "import":
+		importCmd(flag.Args()[1:])
+		return
+	case "":
+	default:
+		fatal("Unknown command (want export or import)", "command", flag.Arg(0))
+	}
+
+	s := server.New(server.Options{
+		Addr:     *httpAddr,
+		GRPCAddr: *grpcAddr,
+		DataFile: *dataFile,
+		Stream:   *streamPeers,
+		Peers:    splitPeers(*peersFlag),
+
+		MaxFetches:   *maxFetches,
+		FetchTimeout: *fetchTimeout,
+		AdminToken:   *adminToken,
+	})
+	if err := s.Start(); err != nil {
+		fatal("Error starting server", "err", err)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case err := <-s.Err():
+			fatal("Error serving", "err", err)
+		case sig := <-sigc:
+			slog.Info("Received signal", "signal", sig)
+			if sig != syscall.SIGHUP {
+				break wait
+			}
+			if err := reload(s); err != nil {
+				slog.Error("Error reloading config (keeping the current settings)", "err", err)
+			}
+		}
+	}
+	signal.Stop(sigc) // a second signal kills the server immediately
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		fatal("Error shutting down", "err", err)
+	}
+}
+
+// cmdlineFlags holds the names of the flags given on the command line, which
+// the config file and environment don't override.
+var cmdlineFlags = make(map[string]bool)
+
+// reload reloads the config file and environment, and applies changes to
+// the peers and fetch limits to s. Peers that were removed from the config
+// are removed from s (but peers added to it in other ways are kept).
+func reload(s *server.Server) error {
+	old := make(map[string]string)
+	for _, name := range configKeys {
+		old[name] = flag.Lookup(name).Value.String()
+	}
+	if err := loadConfig(flag.CommandLine, cmdlineFlags, *configFile, os.LookupEnv); err != nil {
+		// Restore the settings that were loaded before the error.
+		for name, v := range old {
+			flag.Set(name, v)
+		}
+		return err
+	}
+	// Keep the settings that can't be reloaded (such as -shutdown-timeout,
+	// which is read at shutdown) as they were.
+	for key, name := range configKeys {
+		if v := flag.Lookup(name).Value.String(); v != old[name] && !slices.Contains(reloadableKeys, key) {
+			slog.Warn("Setting changed, which takes effect at the next restart", "setting", key)
+			flag.Set(name, old[name])
+		}
+	}
+
+	oldPeers, newPeers := splitPeers(old["peers"]), splitPeers(*peersFlag)
+	var removed []string
+	for _, host := range oldPeers {
+		if !slices.Contains(newPeers, host) {
+			removed = append(removed, host)
+		}
+	}
+	s.RemovePeers(removed...)
+	s.AddPeers(newPeers...)
+	s.SetLimits(*maxFetches, *fetchTimeout)
+	slog.Info("Reloaded config", "peers", s.Peers())
+	return nil
+}
+
+// setupLogging sets the default slog logger (which the log package and the
+// server also write to) according to the -log-format and -log-level flags.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("bad -log-level: %s", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *logFormat {
+	case "text", "logfmt":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad -log-format %q (want text or json)", *logFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
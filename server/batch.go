@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBatchLinks is the maximum number of links accepted by POST
+// /links/batch.
+const maxBatchLinks = 1000
+
+// batchItemResult is the result of one link in POST /links/batch.
+type batchItemResult struct {
+	URL    string
+	Status string // a submitResult, or "deleted", "invalid" or "skipped"
+	Error  string `json:",omitempty"`
+}
+
+// batchResult is the JSON response of POST /links/batch.
+type batchResult struct {
+	// Applied is whether the links were added (if any link was invalid, none
+	// were). Results says what happened to each one.
+	Applied bool
+	Results []batchItemResult
+}
+
+// postLinksBatch handles POST /links/batch, which adds many links at once.
+// The request body is either a JSON array of links or newline-delimited
+// JSON links.
+//
+// All links are validated before any are added, and if any link is
+// invalid, none are added and it responds with 400 Bad Request (reporting
+// the valid links as "skipped"). Otherwise the batch is atomic: its links
+// are merged all at once (see mergeLinks), so that no one sees some of them
+// before the rest, and only then published to event streams and broadcasted.
+// Links without a title are the exception: they are added once their titles
+// are fetched, as with POST /links. Links that were deleted are not an error
+// (they're expected when receiving from peers), and are reported as
+// "deleted".
+func (s *Server) postLinksBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !allowJSON(w, r) {
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	ls, err := readBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setOrigin(r, ls...)
+
+	res := batchResult{Results: make([]batchItemResult, len(ls))}
+	valid := true
+	for i, l := range ls {
+		res.Results[i] = batchItemResult{URL: l.URL, Status: "skipped"}
+		if err := validateURL(l.URL); err != nil {
+			res.Results[i].Status, res.Results[i].Error = "invalid", err.Error()
+			valid = false
+		}
+	}
+
+	w.Header().Set("content-type", "application/json")
+	if !valid {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	res.Applied = true
+	var staged []*link
+	var stagedAt []int
+	for i, l := range ls {
+		sr, err := s.checkLink(l)
+		switch {
+		case err == errLinkDeleted:
+			res.Results[i].Status = "deleted"
+		case err != nil:
+			res.Results[i].Status, res.Results[i].Error = "invalid", err.Error()
+		case sr == submitFetching:
+			go s.fetchAndAdd(l, true)
+			res.Results[i].Status = string(sr)
+		case sr == submitAdded:
+			staged = append(staged, l)
+			stagedAt = append(stagedAt, i)
+			continue // recorded once merged
+		default:
+			res.Results[i].Status = string(sr)
+		}
+		s.recordSubmission(l, submitResult(res.Results[i].Status), err)
+	}
+	for j, changed := range s.addLinks(staged, true) {
+		sr := submitAdded
+		if !changed {
+			sr = submitDuplicate
+		}
+		res.Results[stagedAt[j]].Status = string(sr)
+		s.recordSubmission(staged[j], sr, nil)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// readBatch reads the links in a POST /links/batch request body, which is
+// either a JSON array or newline-delimited JSON.
+func readBatch(r *http.Request) ([]*link, error) {
+	br := bufio.NewReader(r.Body)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, errors.New("empty batch")
+	}
+
+	var ls []*link
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if err := dec.Decode(&ls); err != nil {
+			return nil, fmt.Errorf("bad JSON: %s", err)
+		}
+	} else {
+		for dec.More() {
+			var l *link
+			if err := dec.Decode(&l); err != nil {
+				return nil, fmt.Errorf("bad JSON on link %d: %s", len(ls)+1, err)
+			}
+			ls = append(ls, l)
+			if len(ls) > maxBatchLinks {
+				break
+			}
+		}
+	}
+	if len(ls) > maxBatchLinks {
+		return nil, fmt.Errorf("too many links (max %d)", maxBatchLinks)
+	}
+	for i, l := range ls {
+		if l == nil {
+			return nil, fmt.Errorf("link %d is null", i+1)
+		}
+	}
+	return ls, nil
+}
+
+// peekNonSpace returns the first non-whitespace byte in br, discarding the
+// whitespace before it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		br.Discard(1)
+	}
+}
+
+// errBatchUnsupported is returned by postBatch for peers that don't support
+// POST /links/batch: those that respond with 404 or 405, or with anything
+// other than a JSON batchResult (as a peer with a catch-all handler, such as
+// one written following the workshop, does).
+var errBatchUnsupported = errors.New("peer does not support batches")
+
+// postBatch POSTs ls to the /links/batch endpoint of the peer at host, with
+// the given trace context.
+func (s *Server) postBatch(host string, ls []*link, trace spanContext) (err error) {
+	defer func(t0 time.Time) {
+		if err != errBatchUnsupported {
+			s.recordBroadcast(host, t0, &err)
+		}
+	}(time.Now())
+	body, err := json.Marshal(ls)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/links/batch", host), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	if ids := linkRequestIDs(ls); ids != nil {
+		req.Header.Set(requestIDHeader, strings.Join(ids, ","))
+	}
+	injectTrace(req.Header, trace)
+	resp, err := s.peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var res batchResult
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("content-type"))
+		if mediaType != "application/json" || json.NewDecoder(resp.Body).Decode(&res) != nil || len(res.Results) != len(ls) {
+			return errBatchUnsupported
+		}
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errBatchUnsupported
+	}
+	return statusError(resp.StatusCode)
+}
+
+// linkRequestIDs returns the request ID of each link in ls, or nil if none
+// have one.
+func linkRequestIDs(ls []*link) []string {
+	ids := make([]string, len(ls))
+	var any bool
+	for i, l := range ls {
+		ids[i] = l.requestID
+		any = any || l.requestID != ""
+	}
+	if !any {
+		return nil
+	}
+	return ids
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func decodeBatchResult(t *testing.T, resp *httptest.ResponseRecorder) batchResult {
+	var res batchResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("bad JSON response: %s", err)
+	}
+	return res
+}
+
+// TestAddLinks_Batch tests that POST /links/batch adds all links in a JSON
+// array or NDJSON body and reports the result of each.
+func TestAddLinks_Batch(t *testing.T) {
+	s := newTestServer(t)
+	for name, body := range map[string]string{
+		"array":  `[{"URL":"http://batch.example.com/a1","Title":"A1"}, {"URL":"http://batch.example.com/a2","Title":"A2"}]`,
+		"NDJSON": `{"URL":"http://batch.example.com/n1","Title":"N1"}` + "\n" + `{"URL":"http://batch.example.com/n2","Title":"N2"}` + "\n",
+	} {
+		resp := doRequest(s, "POST", "/links/batch", body)
+		testStatusCode(t, name+" batch", resp.Code, http.StatusOK)
+		res := decodeBatchResult(t, resp)
+		if !res.Applied || len(res.Results) != 2 {
+			t.Fatalf("%s: got %+v, want 2 applied results", name, res)
+		}
+		for _, r := range res.Results {
+			if r.Status != string(submitAdded) {
+				t.Errorf("%s: got status %q for %s, want %q", name, r.Status, r.URL, submitAdded)
+			}
+			if l := s.getLink(LinkID(r.URL)); l == nil {
+				t.Errorf("%s: link %s was not added", name, r.URL)
+			}
+		}
+	}
+
+	// Adding the same link again is reported as a duplicate.
+	resp := doRequest(s, "POST", "/links/batch", `[{"URL":"http://batch.example.com/a1","Title":"A1"}]`)
+	if res := decodeBatchResult(t, resp); res.Results[0].Status != string(submitDuplicate) {
+		t.Errorf("got status %q, want %q", res.Results[0].Status, submitDuplicate)
+	}
+}
+
+// TestAddLinks_BatchInvalid tests that no links in a batch are added if any
+// is invalid.
+func TestAddLinks_BatchInvalid(t *testing.T) {
+	s := newTestServer(t)
+	resp := doRequest(s, "POST", "/links/batch", `[{"URL":"http://batch.example.com/ok","Title":"OK"}, {"URL":"javascript:alert(1)"}]`)
+	testStatusCode(t, "batch with an invalid link", resp.Code, http.StatusBadRequest)
+	res := decodeBatchResult(t, resp)
+	if res.Applied {
+		t.Error("got Applied, want batch to be rejected")
+	}
+	if got := []string{res.Results[0].Status, res.Results[1].Status}; got[0] != "skipped" || got[1] != "invalid" {
+		t.Errorf("got statuses %q, want [skipped invalid]", got)
+	}
+	if l := s.getLink(LinkID("http://batch.example.com/ok")); l != nil {
+		t.Errorf("got link %+v, want valid link in rejected batch not to be added", l)
+	}
+
+	resp = doRequest(s, "POST", "/links/batch", `[{"URL":"http://batch.example.com/ok"}, null]`)
+	testStatusCode(t, "batch with a null link", resp.Code, http.StatusBadRequest)
+}
+
+// TestAddLinks_BatchAtomic tests that others see either none of a batch's
+// links or all of them.
+func TestAddLinks_BatchAtomic(t *testing.T) {
+	s := newTestServer(t)
+	var body strings.Builder
+	const n = 100
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&body, `{"URL":"http://batch.example.com/atomic%d","Title":"Atomic %d"}`+"\n", i, i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		doRequest(s, "POST", "/links/batch", body.String())
+	}()
+	for {
+		select {
+		case <-done:
+			if got := len(s.listLinks()); got != n {
+				t.Fatalf("got %d links after batch, want %d", got, n)
+			}
+			return
+		default:
+		}
+		if got := len(s.listLinks()); got != 0 && got != n {
+			t.Fatalf("got %d links during batch, want 0 or %d", got, n)
+		}
+	}
+}
+
+// TestBroadcast_Batch tests that links queued while a broadcast to a peer is
+// in flight are sent to the peer together with POST /links/batch.
+func TestBroadcast_Batch(t *testing.T) {
+	s := newTestServer(t)
+	singles, batches := make(chan *link, 10), make(chan []*link, 10)
+	unblock := make(chan struct{})
+	fakeMux := http.NewServeMux()
+	fakeMux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		var l *link
+		json.NewDecoder(r.Body).Decode(&l)
+		singles <- l
+		<-unblock // hold up the sender while more links are queued
+	})
+	fakeMux.HandleFunc("/links/batch", func(w http.ResponseWriter, r *http.Request) {
+		var ls []*link
+		json.NewDecoder(r.Body).Decode(&ls)
+		batches <- ls
+		writeBatchResult(w, ls)
+	})
+	fakeServer := httptest.NewServer(fakeMux)
+	defer fakeServer.Close()
+	fakeServerURL, _ := url.Parse(fakeServer.URL)
+	s.AddPeers(fakeServerURL.Host)
+
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/b1","Title":"B1"}`)
+	if l := waitForLink(t, singles); l.Title != "B1" {
+		t.Errorf("got link %+v, want B1", l)
+	}
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/b2","Title":"B2"}`)
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/b3","Title":"B3"}`)
+	close(unblock)
+
+	var ls []*link
+	select {
+	case ls = <-batches:
+	case <-time.After(time.Second):
+		t.Fatal("fake peer did not receive batch")
+	}
+	if len(ls) != 2 || ls[0].Title != "B2" || ls[1].Title != "B3" {
+		t.Errorf("got batch %+v, want B2 and B3", ls)
+	}
+}
+
+// writeBatchResult responds to a fake peer's POST /links/batch with a
+// result adding all of ls.
+func writeBatchResult(w http.ResponseWriter, ls []*link) {
+	res := batchResult{Applied: true}
+	for _, l := range ls {
+		res.Results = append(res.Results, batchItemResult{URL: l.URL, Status: string(submitAdded)})
+	}
+	w.Header().Set("content-type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// TestBroadcast_BatchCatchAll tests that a peer that responds to POST
+// /links/batch with 200 but no batch result (as one with a catch-all
+// handler does) is sent the links again with POST /links, rather than
+// losing them.
+func TestBroadcast_BatchCatchAll(t *testing.T) {
+	s := newTestServer(t)
+	singles := make(chan *link, 10)
+	unblock := make(chan struct{})
+	var once sync.Once
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/links" {
+			var l *link
+			json.NewDecoder(r.Body).Decode(&l)
+			singles <- l
+			once.Do(func() { <-unblock })
+		}
+		w.Header().Set("content-type", "text/html")
+		io.WriteString(w, "<html>Links</html>")
+	}))
+	defer fakeServer.Close()
+	fakeServerURL, _ := url.Parse(fakeServer.URL)
+	s.AddPeers(fakeServerURL.Host)
+
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/c1","Title":"C1"}`)
+	waitForLink(t, singles)
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/c2","Title":"C2"}`)
+	doRequest(s, "POST", "/links", `{"URL":"http://batch.example.com/c3","Title":"C3"}`)
+	close(unblock)
+	for _, want := range []string{"C2", "C3"} {
+		if l := waitForLink(t, singles); l.Title != want {
+			t.Errorf("got link %+v, want %s", l, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Bookmark formats supported by import and export.
+const (
+	FormatHTML  = "html"  // Netscape bookmark file, as exported by browsers
+	FormatJSONL = "jsonl" // JSON Lines of link objects
+	FormatCSV   = "csv"   // URL,Title,Tags with space-separated tags
+)
+
+// FormatFromName guesses the bookmark format of the named file from its
+// extension. It returns FormatJSONL if the extension is unrecognized.
+func FormatFromName(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return FormatHTML
+	case ".csv":
+		return FormatCSV
+	}
+	return FormatJSONL
+}
+
+// ContentType returns the MIME type of a bookmark format, or "" if the
+// format is unknown.
+func ContentType(format string) string { return contentTypes[format] }
+
+// contentTypes holds the MIME type of each bookmark format.
+var contentTypes = map[string]string{
+	FormatHTML:  "text/html; charset=utf-8",
+	FormatJSONL: "application/x-ndjson",
+	FormatCSV:   "text/csv; charset=utf-8",
+}
+
+var netscapeTmpl = template.Must(template.New("netscape").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"unix": func(t time.Time) int64 { return t.Unix() },
+}).Parse(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+{{range .}}    <DT><A HREF="{{html .URL}}"{{if not .Added.IsZero}} ADD_DATE="{{unix .Added}}"{{end}}{{with .Tags}} TAGS="{{html (join . ",")}}"{{end}}>{{html .Title}}</A>
+{{end}}</DL><p>
+`))
+
+// writeLinks writes ls to w in the given bookmark format.
+func writeLinks(w io.Writer, format string, ls []*link) error {
+	switch format {
+	case FormatHTML:
+		return netscapeTmpl.Execute(w, ls)
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, l := range ls {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"URL", "Title", "Tags"})
+		for _, l := range ls {
+			cw.Write([]string{l.URL, l.Title, strings.Join(l.Tags, " ")})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown bookmark format %q", format)
+}
+
+var (
+	netscapeLinkRE = regexp.MustCompile(`(?is)<a\s([^>]*)>(.*?)</a>`)
+	netscapeAttrRE = regexp.MustCompile(`(?is)([a-z_]+)\s*=\s*"([^"]*)"`)
+)
+
+// readLinks reads links in the given bookmark format from r.
+func readLinks(r io.Reader, format string) ([]*link, error) {
+	switch format {
+	case FormatHTML:
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var ls []*link
+		for _, m := range netscapeLinkRE.FindAllStringSubmatch(string(b), -1) {
+			l := &link{Title: strings.TrimSpace(html.UnescapeString(m[2]))}
+			for _, attr := range netscapeAttrRE.FindAllStringSubmatch(m[1], -1) {
+				val := html.UnescapeString(attr[2])
+				switch strings.ToUpper(attr[1]) {
+				case "HREF":
+					l.URL = val
+				case "TAGS":
+					l.Tags = splitTags(val, ",")
+				case "ADD_DATE":
+					if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+						l.Added = time.Unix(sec, 0)
+					}
+				}
+			}
+			ls = append(ls, l)
+		}
+		return ls, nil
+	case FormatJSONL:
+		var ls []*link
+		s := bufio.NewScanner(r)
+		s.Buffer(nil, 1<<20)
+		for line := 1; s.Scan(); line++ {
+			if strings.TrimSpace(s.Text()) == "" {
+				continue
+			}
+			var l *link
+			if err := json.Unmarshal(s.Bytes(), &l); err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+			if l == nil {
+				return nil, fmt.Errorf("line %d: link is null", line)
+			}
+			ls = append(ls, l)
+		}
+		return ls, s.Err()
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		// Skip the header row, if any.
+		if len(records) > 0 && strings.EqualFold(records[0][0], "url") {
+			records = records[1:]
+		}
+		var ls []*link
+		for _, rec := range records {
+			l := &link{URL: strings.TrimSpace(rec[0])}
+			if len(rec) > 1 {
+				l.Title = strings.TrimSpace(rec[1])
+			}
+			if len(rec) > 2 {
+				l.Tags = splitTags(rec[2], " ")
+			}
+			ls = append(ls, l)
+		}
+		return ls, nil
+	}
+	return nil, fmt.Errorf("unknown bookmark format %q", format)
+}
+
+func splitTags(s, sep string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, sep) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ImportResult is the JSON response of POST /import.
+type ImportResult struct {
+	Imported   int      // links added
+	Duplicates int      // links we already had (or that were repeated)
+	Fetching   int      // links without titles, added after fetching
+	Errors     []string `json:",omitempty"` // links that couldn't be added, which were skipped
+}
+
+// importLinks adds ls with submit, skipping duplicates. If share is true,
+// the imported links are broadcasted to peers.
+func (s *Server) importLinks(ls []*link, share bool) ImportResult {
+	var res ImportResult
+	seen := make(map[string]bool, len(ls))
+	for _, l := range ls {
+		// Unlike submitLink, importing skips links we already have, rather
+		// than merging them, so that re-importing an old export doesn't
+		// change their titles or tags.
+		if _, known := s.links.Get(l.URL); known || seen[l.URL] {
+			s.recordSubmission(l, submitDuplicate, nil)
+			res.Duplicates++
+			continue
+		}
+		switch sr, err := s.submit(l, share); {
+		case err != nil:
+			res.Errors = append(res.Errors, fmt.Sprintf("%q: %s", l.URL, err))
+			continue
+		case sr == submitFetching:
+			res.Fetching++
+		case sr == submitDuplicate:
+			res.Duplicates++
+		default:
+			res.Imported++
+		}
+		seen[l.URL] = true
+	}
+	return res
+}
+
+// serveExport handles GET /export, which returns all links in the bookmark
+// format given by the "format" query parameter (default "jsonl").
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = FormatJSONL
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("content-type", contentType)
+	if err := writeLinks(w, format, s.listLinks()); err != nil {
+		slog.Error("Error exporting links", "err", err)
+	}
+}
+
+// serveImport handles POST /import, which adds the links in the request
+// body, in the bookmark format given by the "format" query parameter
+// (default "jsonl"). Imported links are broadcasted to peers only if the
+// "broadcast" query parameter is true.
+func (s *Server) serveImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !allowJSON(w, r) {
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSONL
+	}
+	share, _ := strconv.ParseBool(r.URL.Query().Get("broadcast"))
+	ls, err := readLinks(r.Body, format)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad %s: %s", format, err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	setOrigin(r, ls...)
+	json.NewEncoder(w).Encode(s.importLinks(ls, share))
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBookmarkFormats tests that links survive a round trip through each
+// bookmark format.
+func TestBookmarkFormats(t *testing.T) {
+	ls := []*link{
+		{URL: "http://example.com/?a=1&b=2", Title: `Quotes " & <tags>`, Tags: []string{"go", "web"}, Added: time.Unix(1394000000, 0)},
+		{URL: "http://example.com/2", Title: "Second, with comma"},
+	}
+	for _, format := range []string{FormatHTML, FormatJSONL, FormatCSV} {
+		var buf bytes.Buffer
+		if err := writeLinks(&buf, format, ls); err != nil {
+			t.Errorf("%s: writeLinks: %s", format, err)
+			continue
+		}
+		got, err := readLinks(&buf, format)
+		if err != nil {
+			t.Errorf("%s: readLinks: %s", format, err)
+			continue
+		}
+		if len(got) != len(ls) {
+			t.Errorf("%s: got %d links, want %d", format, len(got), len(ls))
+			continue
+		}
+		for i, l := range got {
+			if l.URL != ls[i].URL || l.Title != ls[i].Title || !reflect.DeepEqual(l.Tags, ls[i].Tags) {
+				t.Errorf("%s: got link %+v, want %+v", format, l, ls[i])
+			}
+		}
+	}
+}
+
+// TestImport tests that importing links adds them, skipping duplicates, and
+// that they can then be exported.
+func TestImport(t *testing.T) {
+	s := newTestServer(t)
+	body := `URL,Title,Tags
+http://import.example.com/1,Imported 1,imported
+http://import.example.com/2,Imported 2,
+http://import.example.com/1,Imported 1 again,
+`
+	resp := doRequest(s, "POST", "/import?format=csv", body)
+	testStatusCode(t, "after importing links", resp.Code, http.StatusOK)
+	var res ImportResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportResult{Imported: 2, Duplicates: 1}); !reflect.DeepEqual(res, want) {
+		t.Errorf("got import result %+v, want %+v", res, want)
+	}
+
+	resp = doRequest(s, "POST", "/import?format=csv", body)
+	json.NewDecoder(resp.Body).Decode(&res)
+	if res.Imported != 0 || res.Duplicates != 3 {
+		t.Errorf("got import result %+v after re-importing, want all duplicates", res)
+	}
+
+	resp = doRequest(s, "GET", "/export?format=html", "")
+	testStatusCode(t, "exporting links", resp.Code, http.StatusOK)
+	if body := resp.Body.String(); !strings.Contains(body, "Imported 2") || !strings.Contains(body, `TAGS="imported"`) {
+		t.Errorf("want imported links in export, got %q", body)
+	}
+}
+
+// TestImport_NullLink tests that a JSONL import with a null line is rejected
+// (without adding any of its links).
+func TestImport_NullLink(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"URL":"http://import.example.com/null1","Title":"Before null"}` + "\nnull\n"
+	resp := doRequest(s, "POST", "/import?format=jsonl", body)
+	testStatusCode(t, "importing a null link", resp.Code, http.StatusBadRequest)
+	if !strings.Contains(resp.Body.String(), "line 2: link is null") {
+		t.Errorf("got body %q, want error for line 2", resp.Body.String())
+	}
+	if l := s.getLink(LinkID("http://import.example.com/null1")); l != nil {
+		t.Errorf("got link %+v, want none imported", l)
+	}
+}
@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/sourcegraph/gophurls/sim"
+)
+
+// TestAddLink_NoTitle_Broadcast tests that a newly added link with no title
+// (and which therefore needs to be fetched) is broadcasted to peers after
+// fetching completes.
+func TestAddLink_NoTitle_Broadcast(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		// Serve a page with a <title> tag for the server to fetch.
+		fetches := 0
+		web := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(`<title>Example</title>`))
+			fetches++
+		})
+		c := sim.New(sim.Config{Nodes: 2, Web: web})
+		defer c.Close()
+
+		// Add the link to node0, and test that node0 fetched it and
+		// broadcasted it (with its title) to its peer.
+		c.Nodes[0].Add("http://example.com", "")
+		if !c.Run(time.Minute) {
+			t.Fatal("cluster did not become idle")
+		}
+		if fetches != 1 {
+			t.Errorf("got %d fetches, want 1", fetches)
+		}
+		if got, want := c.Nodes[1].Title("http://example.com"), "Example"; got != want {
+			t.Errorf("got title %q on peer, want %q", got, want)
+		}
+	})
+}
+
+// TestAddLink_WithTitle_Broadcast tests that a newly added link with a
+// title (and which therefore does not need to be fetched) is immediately
+// broadcasted to peers.
+func TestAddLink_WithTitle_Broadcast(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		c := sim.New(sim.Config{Nodes: 2})
+		defer c.Close()
+
+		// Add the link to node0, and test that node0 broadcasted it to its
+		// peer right away (within the time a request takes, at most 10ms).
+		c.Nodes[0].Add("http://example.com", "Example")
+		c.RunFor(10 * time.Millisecond)
+		if got, want := c.Nodes[1].Title("http://example.com"), "Example"; got != want {
+			t.Errorf("got title %q on peer, want %q", got, want)
+		}
+	})
+}
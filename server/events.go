@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// Event types published by the event hub.
+const (
+	linkAdded   = "link-added"
+	linkUpdated = "link-updated"
+	linkDeleted = "link-deleted"
+)
+
+// event is a change to a link, as sent to /events subscribers.
+type event struct {
+	ID   string `json:"-"`
+	Type string `json:"-"`
+
+	LinkID string
+	Link   *link
+}
+
+// maxEventHistory is the number of recent events kept so that subscribers
+// that reconnect can resume where they left off.
+const maxEventHistory = 256
+
+// eventHub publishes link events to subscribers. Event IDs are
+// "epoch-seq", where epoch identifies this run of the server, so that a
+// Last-Event-ID from before a restart is recognized as stale.
+type eventHub struct {
+	epoch string
+
+	mu      sync.Mutex
+	seq     int64
+	history []event // the most recent events, oldest first
+	subs    map[chan event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:  make(map[chan event]struct{}),
+	}
+}
+
+// publish sends an event to all subscribers. Subscribers that have fallen
+// too far behind are dropped; they can reconnect and resume from history.
+func (h *eventHub) publish(typ string, l *link) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	e := event{ID: fmt.Sprintf("%s-%d", h.epoch, h.seq), Type: typ, LinkID: LinkID(l.URL), Link: l}
+	h.history = append(h.history, e)
+	if len(h.history) > maxEventHistory {
+		h.history = h.history[len(h.history)-maxEventHistory:]
+	}
+	for c := range h.subs {
+		select {
+		case c <- e:
+		default:
+			delete(h.subs, c)
+			close(c)
+		}
+	}
+}
+
+// subscribe returns a channel of events published after the event with ID
+// lastID (or after now, if lastID is empty). If the events since lastID are
+// no longer in history, ok is false and the subscriber should start over.
+func (h *eventHub) subscribe(lastID string) (c chan event, missed []event, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ok = true
+	if lastID != "" {
+		missed, ok = h.since(lastID)
+	}
+	c = make(chan event, 64)
+	h.subs[c] = struct{}{}
+	return c, missed, ok
+}
+
+// since returns the events in history after the event with ID lastID.
+func (h *eventHub) since(lastID string) ([]event, bool) {
+	epoch, seqStr, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if epoch != h.epoch || err != nil || seq > h.seq {
+		return nil, false
+	}
+	if seq == h.seq {
+		return nil, true
+	}
+	oldest := h.seq - int64(len(h.history)) + 1
+	if seq+1 < oldest {
+		return nil, false
+	}
+	return append([]event(nil), h.history[seq+1-oldest:]...), true
+}
+
+// lastID returns the ID of the most recently published event.
+func (h *eventHub) lastID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return fmt.Sprintf("%s-%d", h.epoch, h.seq)
+}
+
+// unsubscribe stops sending events to c.
+func (h *eventHub) unsubscribe(c chan event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, present := h.subs[c]; present {
+		delete(h.subs, c)
+		close(c)
+	}
+}
+
+// publishChange publishes the event for a link whose replicated state
+// changed from before to after.
+func (s *Server) publishChange(url string, before, after crdt.Entry[crdt.Link]) {
+	var typ string
+	switch {
+	case before.Present() && !after.Present():
+		typ = linkDeleted
+	case !after.Present():
+		return // changes to deleted links aren't visible
+	case !before.Present():
+		typ = linkAdded
+	default:
+		typ = linkUpdated
+	}
+	s.events.publish(typ, s.linkFromEntry(url, after))
+}
+
+// heartbeatInterval is how often a comment is sent to idle /events
+// subscribers, to keep proxies from closing the connection.
+const heartbeatInterval = 15 * time.Second
+
+// serveEvents handles GET /events, a Server-Sent Events stream of link
+// events. Clients that reconnect with a Last-Event-ID header (as
+// EventSource does automatically) receive the events they missed, or a
+// "reset" event if those are no longer available.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	lastID := r.Header.Get("last-event-id")
+	if lastID == "" {
+		lastID = r.FormValue("lastEventId")
+	}
+	c, missed, ok := s.events.subscribe(lastID)
+	defer s.events.unsubscribe(c)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	if !ok {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				return // we fell behind; the client will reconnect and resume
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Error encoding event", "id", e.ID, "err", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next event (skipping comments) from an SSE stream.
+func readEvent(t *testing.T, r *bufio.Reader) (id, typ string, e event) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Errorf("reading event: %s", err)
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && typ != "":
+				return
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return id, typ, e
+}
+
+func subscribeEvents(t *testing.T, server *httptest.Server, lastID string) (*bufio.Reader, func()) {
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	if lastID != "" {
+		req.Header.Set("last-event-id", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Errorf("got content-type %q, want text/event-stream", ct)
+	}
+	return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+}
+
+// TestEvents tests that adding, editing and deleting a link publishes events
+// to /events subscribers, and that subscribers can resume with
+// Last-Event-ID.
+func TestEvents(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	stream, closeStream := subscribeEvents(t, server, "")
+	defer closeStream()
+
+	const u = "http://events.example.com/"
+	doRequest(s, "POST", "/links", `{"URL":"`+u+`","Title":"Events"}`)
+	doRequest(s, "PATCH", "/links/"+LinkID(u), `{"Title":"Edited"}`)
+	doRequest(s, "DELETE", "/links/"+LinkID(u), "")
+
+	firstID, typ, e := readEvent(t, stream)
+	if typ != linkAdded || e.Link.Title != "Events" || e.LinkID != LinkID(u) {
+		t.Errorf("got event %s %+v, want %s of link with title Events", typ, e, linkAdded)
+	}
+	if _, typ, e = readEvent(t, stream); typ != linkUpdated || e.Link.Title != "Edited" {
+		t.Errorf("got event %s %+v, want %s of link with title Edited", typ, e, linkUpdated)
+	}
+	if _, typ, _ = readEvent(t, stream); typ != linkDeleted {
+		t.Errorf("got event %s, want %s", typ, linkDeleted)
+	}
+
+	// Resuming after the first event replays the ones after it.
+	resumed, closeResumed := subscribeEvents(t, server, firstID)
+	defer closeResumed()
+	if _, typ, _ = readEvent(t, resumed); typ != linkUpdated {
+		t.Errorf("got event %s after resuming, want %s", typ, linkUpdated)
+	}
+
+	// Resuming from an unknown event (such as from before a restart) resets.
+	reset, closeReset := subscribeEvents(t, server, "stale-1")
+	defer closeReset()
+	if _, typ, _ = readEvent(t, reset); typ != "reset" {
+		t.Errorf("got event %s after resuming from stale ID, want reset", typ)
+	}
+}
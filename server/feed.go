@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFeedItems is the maximum number of links included in a feed.
+const maxFeedItems = 50
+
+// updated returns when l was last changed, which is when it was added or
+// when its title or tags were last edited, whichever is later.
+func (l *link) updated() time.Time {
+	t := l.Added
+	if l.State != nil {
+		for _, r := range []int64{l.State.Value.Title.Time.Wall, l.State.Value.Tags.Time.Wall} {
+			if rt := time.Unix(0, r); r != 0 && rt.After(t) {
+				t = rt
+			}
+		}
+	}
+	return t
+}
+
+// feedLinks returns the newest links to include in the feed requested by r,
+// which may be filtered by the "tag" and "domain" query parameters, along
+// with a description of the filter (for the feed title).
+func (s *Server) feedLinks(r *http.Request) (ls []*link, filter string) {
+	tag, domain := r.FormValue("tag"), strings.ToLower(r.FormValue("domain"))
+	for _, l := range s.listLinks() {
+		if tag != "" && !hasTag(l, tag) {
+			continue
+		}
+		if domain != "" && !inDomain(l, domain) {
+			continue
+		}
+		ls = append(ls, l)
+	}
+	sort.SliceStable(ls, func(i, j int) bool { return ls[i].updated().After(ls[j].updated()) })
+	if len(ls) > maxFeedItems {
+		ls = ls[:maxFeedItems]
+	}
+
+	var filters []string
+	if tag != "" {
+		filters = append(filters, "tagged "+tag)
+	}
+	if domain != "" {
+		filters = append(filters, "on "+domain)
+	}
+	return ls, strings.Join(filters, " ")
+}
+
+func hasTag(l *link, tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// inDomain reports whether l's URL is on domain or one of its subdomains.
+func inDomain(l *link, domain string) bool {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title      string   `xml:"title"`
+	Link       string   `xml:"link"`
+	GUID       string   `xml:"guid"`
+	PubDate    string   `xml:"pubDate"`
+	Categories []string `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// feedTitle returns the title of a feed with the given filter description.
+func feedTitle(filter string) string {
+	if filter == "" {
+		return "GophURLs"
+	}
+	return "GophURLs: links " + filter
+}
+
+// serveRSS handles GET /feed.rss, which returns an RSS 2.0 feed of links.
+func (s *Server) serveRSS(w http.ResponseWriter, r *http.Request) {
+	ls, filter := s.feedLinks(r)
+	home := "http://" + r.Host + "/"
+	feed := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feedTitle(filter),
+			Link:        home,
+			Description: "Links shared on GophURLs",
+		},
+	}
+	for _, l := range ls {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:      l.Title,
+			Link:       l.URL,
+			GUID:       l.URL,
+			PubDate:    l.Added.UTC().Format(time.RFC1123Z),
+			Categories: l.Tags,
+		})
+	}
+	serveFeed(w, r, "application/rss+xml; charset=utf-8", feed)
+}
+
+// serveAtom handles GET /feed.atom, which returns an Atom feed of links.
+func (s *Server) serveAtom(w http.ResponseWriter, r *http.Request) {
+	ls, filter := s.feedLinks(r)
+	home := "http://" + r.Host + "/"
+	feed := atomFeed{
+		Title: feedTitle(filter),
+		ID:    "http://" + r.Host + r.URL.RequestURI(),
+		Links: []atomLink{
+			{Href: home},
+			{Href: "http://" + r.Host + r.URL.RequestURI(), Rel: "self"},
+		},
+	}
+	var updated time.Time
+	for _, l := range ls {
+		e := atomEntry{
+			Title:   l.Title,
+			ID:      l.URL,
+			Updated: l.updated().UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: l.URL}},
+		}
+		for _, tag := range l.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, e)
+		if l.updated().After(updated) {
+			updated = l.updated()
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	serveFeed(w, r, "application/atom+xml; charset=utf-8", feed)
+}
+
+// serveFeed writes feed as XML. It sets an ETag derived from the feed's
+// contents, and responds with 304 Not Modified if the client already has
+// the current version.
+func serveFeed(w http.ResponseWriter, r *http.Request, contentType string, feed interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(feed); err != nil {
+		slog.Error("Error encoding feed", "err", err)
+		http.Error(w, "error encoding feed", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+	w.Header().Set("etag", etag)
+	if etagMatches(r.Header.Get("if-none-match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("content-type", contentType)
+	w.Write(buf.Bytes())
+}
+
+// etagMatches reports whether etag is in the list of ETags in an
+// If-None-Match header.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
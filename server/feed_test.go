@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFeeds tests that the RSS and Atom feeds are valid XML that includes
+// (correctly escaped) links, and that they can be filtered by tag and
+// domain.
+func TestFeeds(t *testing.T) {
+	s := newTestServer(t)
+	doRequest(s, "POST", "/links", `{"URL":"http://feed.example.com/a?x=1&y=2","Title":"<script>alert(1)</script> & more","Tags":["feedtag"]}`)
+	doRequest(s, "POST", "/links", `{"URL":"http://other.example.org/b","Title":"Other"}`)
+
+	tests := []struct {
+		path        string
+		wantTitles  []string
+		wantMissing []string
+	}{
+		{"/feed.rss", []string{"<script>alert(1)</script> & more", "Other"}, nil},
+		{"/feed.atom", []string{"<script>alert(1)</script> & more", "Other"}, nil},
+		{"/feed.rss?tag=feedtag", []string{"<script>alert(1)</script> & more"}, []string{"Other"}},
+		{"/feed.atom?domain=example.org", []string{"Other"}, []string{"<script>alert(1)</script> & more"}},
+	}
+	for _, test := range tests {
+		resp := doRequest(s, "GET", test.path, "")
+		testStatusCode(t, test.path, resp.Code, http.StatusOK)
+		body := resp.Body.String()
+		if strings.Contains(body, "<script>") {
+			t.Errorf("%s: got unescaped <script> in %q", test.path, body)
+		}
+
+		// Decode just the titles, which works for both RSS and Atom.
+		var feed struct {
+			Titles []string `xml:"channel>item>title"`
+			Atom   []string `xml:"entry>title"`
+		}
+		if err := xml.Unmarshal(resp.Body.Bytes(), &feed); err != nil {
+			t.Errorf("%s: invalid XML: %s", test.path, err)
+			continue
+		}
+		titles := strings.Join(append(feed.Titles, feed.Atom...), "\n")
+		for _, want := range test.wantTitles {
+			if !strings.Contains(titles, want) {
+				t.Errorf("%s: want title %q in feed, got titles %q", test.path, want, titles)
+			}
+		}
+		for _, missing := range test.wantMissing {
+			if strings.Contains(titles, missing) {
+				t.Errorf("%s: want title %q not in feed, got titles %q", test.path, missing, titles)
+			}
+		}
+	}
+}
+
+// TestFeeds_ETag tests that requesting a feed with the ETag of the current
+// version returns 304 Not Modified.
+func TestFeeds_ETag(t *testing.T) {
+	s := newTestServer(t)
+	resp := doRequest(s, "GET", "/feed.atom", "")
+	etag := resp.Header().Get("etag")
+	if etag == "" {
+		t.Fatal("no ETag in feed response")
+	}
+
+	req, _ := http.NewRequest("GET", "/feed.atom", nil)
+	req.Header.Set("if-none-match", etag)
+	resp = httptest.NewRecorder()
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "feed with matching If-None-Match", resp.Code, http.StatusNotModified)
+
+	req, _ = http.NewRequest("GET", "/feed.atom", nil)
+	req.Header.Set("if-none-match", `"stale"`)
+	resp = httptest.NewRecorder()
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "feed with stale If-None-Match", resp.Code, http.StatusOK)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxFetches is the maximum number of title fetches that may run at
+// once, unless Options.MaxFetches is set.
+const defaultMaxFetches = 10
+
+// fetchAndAdd fetches the title of l, then adds it with addLink. It is a
+// no-op if l is already being fetched.
+func (s *Server) fetchAndAdd(l *link, share bool) {
+	id := LinkID(l.URL)
+	s.fetchingMu.Lock()
+	if _, present := s.fetching[id]; present {
+		s.fetchingMu.Unlock()
+		return
+	}
+	s.fetching[id] = l
+	s.fetchingMu.Unlock()
+
+	defer func() {
+		s.fetchingMu.Lock()
+		delete(s.fetching, id)
+		s.fetchingMu.Unlock()
+	}()
+
+	s.fetchLimit.acquire()
+	t0 := time.Now()
+	span := s.startSpan(l.trace, "fetch title", spanClient)
+	span.setAttr("url.full", l.URL)
+	ctx := context.Background()
+	if timeout := time.Duration(s.fetchTimeout.Load()); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	title, err := s.fetchTitle(ctx, l.URL, l.requestID, span.context(l.trace))
+	span.finish(err)
+	s.fetchLimit.release()
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	s.metrics.titleFetches.inc(outcome)
+	s.metrics.titleFetchDuration.observeSince(t0, outcome)
+	if err != nil {
+		slog.Warn("Error fetching title (using URL as title)", "url", l.URL, "err", err, "request_id", l.requestID)
+		title = l.URL
+	} else {
+		slog.Debug("Fetched title", "url", l.URL, "title", title, "request_id", l.requestID)
+	}
+	l.Title = title
+	s.addLink(l, share)
+}
+
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// maxTitleBody is the maximum number of bytes of a page that is read when
+// looking for its <title>.
+const maxTitleBody = 1 << 20
+
+// fetchTitle fetches the HTML page at url and returns the contents of its
+// <title> element. The request carries the given request ID (if any) and
+// trace context, and is canceled when ctx is done.
+func (s *Server) fetchTitle(ctx context.Context, url, requestID string, trace spanContext) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	injectTrace(req.Header, trace)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTitleBody))
+	if err != nil {
+		return "", err
+	}
+	m := titleRE.FindSubmatch(body)
+	if m == nil {
+		return "", errors.New("no <title> found")
+	}
+	title := strings.TrimSpace(html.UnescapeString(string(m[1])))
+	if title == "" {
+		return "", errors.New("empty <title>")
+	}
+	return title, nil
+}
+
+// limiter limits how many operations run at once, to a limit that can be
+// changed while they run.
+type limiter struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	running int
+	limit   int
+}
+
+func newLimiter(limit int) *limiter {
+	l := &limiter{limit: limit}
+	l.cond.L = &l.mu
+	return l
+}
+
+// acquire waits until fewer than the limit of operations are running, and
+// then counts one more.
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.running >= l.limit {
+		l.cond.Wait()
+	}
+	l.running++
+}
+
+// release counts one less running operation.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.cond.Signal()
+}
+
+// setLimit changes the limit. Operations already running over a lowered
+// limit are not stopped.
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// inFlight returns the number of operations running.
+func (l *limiter) inFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLimiter tests that a limiter runs no more than its limit of
+// operations at once, and that raising the limit lets waiting operations
+// run.
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2)
+	var running, peak atomic.Int32
+	started := make(chan struct{}, 4)
+	unblock := make(chan struct{})
+	for range 4 {
+		go func() {
+			l.acquire()
+			defer l.release()
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			started <- struct{}{}
+			<-unblock
+			running.Add(-1)
+		}()
+	}
+
+	waitStarted := func(n int) {
+		for range n {
+			select {
+			case <-started:
+			case <-time.After(time.Second):
+				t.Fatal("operation did not start")
+			}
+		}
+	}
+	waitStarted(2)
+	select {
+	case <-started:
+		t.Fatal("more operations started than the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.setLimit(4)
+	waitStarted(2)
+	close(unblock)
+	if got := peak.Load(); got != 4 {
+		t.Errorf("got %d operations at once after raising the limit, want 4", got)
+	}
+}
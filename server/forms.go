@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isForm reports whether r's body is HTML form data (as opposed to a JSON
+// link). Because `curl -d` sends JSON with the form content type, a form
+// body that starts with "{" is treated as JSON. Another site can send such
+// a body (with fetch, or a text/plain form) without a CSRF token, so JSON
+// bodies must be checked with allowJSON.
+func isForm(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+	switch mediaType {
+	case "multipart/form-data":
+		return true
+	case "application/x-www-form-urlencoded":
+		// The server closes the original body, so it's OK to replace it
+		// with a reader that can't be closed.
+		br := bufio.NewReader(r.Body)
+		r.Body = io.NopCloser(br)
+		b, _ := br.Peek(1)
+		return len(b) == 0 || b[0] != '{'
+	}
+	return false
+}
+
+// allowJSON reports whether the JSON (or other non-form) body of r may be
+// used, responding with an error if not. Browsers only let other sites send
+// bodies with the content types that forms can send (or none) without first
+// asking with a CORS preflight request, which we never allow. So requests
+// with those content types must not come from another site, according to
+// their Sec-Fetch-Site or Origin header. (Clients that aren't browsers, like
+// curl, send neither.)
+func allowJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+	switch mediaType {
+	case "", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data":
+	default:
+		return true
+	}
+	if isCrossSite(r) {
+		http.Error(w, "cross-site requests must have a JSON content type", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// isCrossSite reports whether r was sent by a browser from a page on
+// another site (or origin).
+func isCrossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// postLinkForm handles a link submitted with the form on the homepage or the
+// share page. It redirects back to the homepage, with a flash message
+// saying whether the link was added.
+func (s *Server) postLinkForm(w http.ResponseWriter, r *http.Request) {
+	if !validCSRF(r) {
+		http.Error(w, "invalid or missing CSRF token (reload the page and try again)", http.StatusForbidden)
+		return
+	}
+	l := &link{
+		URL:   strings.TrimSpace(r.FormValue("url")),
+		Title: strings.TrimSpace(r.FormValue("title")),
+		Tags:  splitTags(r.FormValue("tags"), " "),
+	}
+	setOrigin(r, l)
+	switch res, err := s.submitLink(l); {
+	case err != nil:
+		setFlash(w, fmt.Sprintf("Couldn't add %s: %s.", l.URL, err))
+	case res == submitDuplicate:
+		setFlash(w, fmt.Sprintf("%s was already shared.", l.URL))
+	case res == submitFetching:
+		setFlash(w, fmt.Sprintf("Added %s. It will appear once its title has been fetched.", l.URL))
+	default:
+		setFlash(w, fmt.Sprintf("Added %s.", l.Title))
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// serveShare handles GET /share?url=...&title=..., which shows a form
+// prefilled with the given link, for adding it with one click. It is opened
+// by the bookmarklet on the homepage.
+func serveShare(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, "share", struct {
+		URL, Title string
+		CSRFToken  string
+	}{
+		URL:       r.FormValue("url"),
+		Title:     r.FormValue("title"),
+		CSRFToken: csrfToken(w, r),
+	})
+}
+
+// bookmarklet returns a javascript: URL that, when bookmarked and clicked,
+// opens the share page of the server at host for the current page.
+func bookmarklet(host string) template.URL {
+	js := fmt.Sprintf(`location.href='http://%s/share?url='+encodeURIComponent(location.href)+'&title='+encodeURIComponent(document.title)`, template.JSEscapeString(host))
+	return template.URL("javascript:" + url.PathEscape(js))
+}
+
+const csrfCookie = "csrf"
+
+// csrfToken returns the CSRF token for the user making r, setting a new one
+// in a cookie if the user has none. Forms must include the token in a
+// "csrf" field, which validCSRF compares with the cookie. A cross-site form
+// can't read the cookie, so it can't include the token.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	return token
+}
+
+// validCSRF reports whether the form submitted in r includes the user's
+// CSRF token.
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.FormValue(csrfCookie))) == 1
+}
+
+const flashCookie = "flash"
+
+// setFlash sets a message to be shown on the next page the user views.
+func setFlash(w http.ResponseWriter, msg string) {
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Value: url.QueryEscape(msg), Path: "/", HttpOnly: true})
+}
+
+// popFlash returns the message set by setFlash (if any) and clears it.
+func popFlash(w http.ResponseWriter, r *http.Request) string {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+	msg, _ := url.QueryUnescape(c.Value)
+	return msg
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postForm(s *Server, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/links", strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	s.Handler().ServeHTTP(resp, req)
+	return resp
+}
+
+// TestAddLink_Form tests that a link submitted with the homepage form is
+// added and that a flash message is shown afterwards.
+func TestAddLink_Form(t *testing.T) {
+	s := newTestServer(t)
+	form := url.Values{"url": {"http://form.example.com/"}, "title": {"Form link"}, "tags": {"a b"}}
+
+	// Without a CSRF token.
+	resp := postForm(s, form)
+	testStatusCode(t, "after submitting form without CSRF token", resp.Code, http.StatusForbidden)
+
+	// With a CSRF token.
+	token := &http.Cookie{Name: csrfCookie, Value: "token"}
+	form.Set("csrf", "token")
+	resp = postForm(s, form, token)
+	testStatusCode(t, "after submitting form", resp.Code, http.StatusSeeOther)
+	var flash *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == flashCookie {
+			flash = c
+		}
+	}
+	if flash == nil {
+		t.Fatal("no flash message set after submitting form")
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(flash)
+	resp = httptest.NewRecorder()
+	s.Handler().ServeHTTP(resp, req)
+	body := resp.Body.String()
+	if !strings.Contains(body, "Form link") {
+		t.Errorf(`want "Form link" on homepage after submitting form, got %q`, body)
+	}
+	if !strings.Contains(body, `class="flash">Added Form link.`) {
+		t.Errorf("want flash message on homepage after submitting form, got %q", body)
+	}
+}
+
+// TestAddLink_CurlJSON tests that JSON sent with the form content type (as
+// `curl -d` does) is still accepted without a CSRF token.
+func TestAddLink_CurlJSON(t *testing.T) {
+	s := newTestServer(t)
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"http://curl.example.com","Title":"Curl"}`))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "after adding a link with curl", resp.Code, http.StatusOK)
+	if l := s.getLink(LinkID("http://curl.example.com")); l == nil {
+		t.Error("link added with curl was not added")
+	}
+}
+
+// TestAddLink_CrossSiteJSON tests that a JSON body sent from another site
+// with a content type that needs no CORS preflight (as a text/plain form or
+// a no-cors fetch can) is rejected, so that it can't bypass CSRF
+// protection, but that the same body with application/json is accepted.
+func TestAddLink_CrossSiteJSON(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"URL":"http://csrf.example.com","Title":"CSRF"}`
+	for name, header := range map[string][2]string{
+		"Sec-Fetch-Site": {"Sec-Fetch-Site", "cross-site"},
+		"Origin":         {"Origin", "http://evil.example.com"},
+	} {
+		for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+			resp := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/links", strings.NewReader(body))
+			if contentType != "" {
+				req.Header.Set("content-type", contentType)
+			}
+			req.Header.Set(header[0], header[1])
+			s.Handler().ServeHTTP(resp, req)
+			testStatusCode(t, fmt.Sprintf("%s %q body with cross-site %s", name, contentType, header[0]), resp.Code, http.StatusForbidden)
+		}
+	}
+	if l := s.getLink(LinkID("http://csrf.example.com")); l != nil {
+		t.Fatalf("got link %+v, want cross-site link not to be added", l)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/links", strings.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("Origin", "http://evil.example.com")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "JSON body with cross-site Origin", resp.Code, http.StatusOK)
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"http://same.example.com","Title":"Same"}`))
+	req.Header.Set("content-type", "text/plain")
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "text/plain body from the same origin", resp.Code, http.StatusOK)
+}
+
+// TestShare tests that the share page is prefilled with the given link and
+// that the homepage links to a bookmarklet for it.
+func TestShare(t *testing.T) {
+	s := newTestServer(t)
+	resp := doRequest(s, "GET", "/share?url=http%3A%2F%2Fshare.example.com&title=%3Cb%3EShared%3C%2Fb%3E", "")
+	testStatusCode(t, "share page", resp.Code, http.StatusOK)
+	body := resp.Body.String()
+	if !strings.Contains(body, `value="http://share.example.com"`) || !strings.Contains(body, `value="&lt;b&gt;Shared&lt;/b&gt;"`) {
+		t.Errorf("want share form prefilled with (escaped) link, got %q", body)
+	}
+	if !strings.Contains(body, `name="csrf" value="`) {
+		t.Errorf("want CSRF token in share form, got %q", body)
+	}
+
+	if body := doRequest(s, "GET", "/", "").Body.String(); !strings.Contains(body, `href="javascript:location.href=`) {
+		t.Errorf("want bookmarklet on homepage, got %q", body)
+	}
+}
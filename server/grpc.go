@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The gRPC API (see gophurls.proto) is served over unencrypted HTTP/2 by the
+// standard library's HTTP server. Each message on a gRPC stream is prefixed
+// by a compressed flag byte and its 4-byte big-endian length, and the
+// status of the call is sent in the grpc-status and grpc-message trailers.
+
+// grpcService is the full name of the gRPC service in gophurls.proto.
+const grpcService = "gophurls.Gophurls"
+
+// maxGRPCMessage is the maximum size of a gRPC request message.
+const maxGRPCMessage = 4 << 20
+
+// gRPC status codes (see
+// https://github.com/grpc/grpc/blob/master/doc/statuscodes.md).
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is an error with a gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return fmt.Sprintf("gRPC status %d: %s", e.code, e.msg) }
+
+// grpcMethods holds the unary methods of the gRPC service, which each take
+// the call and its encoded request message and return an encoded response
+// message.
+var grpcMethods = map[string]func(s *Server, r *http.Request, req []byte) ([]byte, error){
+	"AddLink":   (*Server).grpcAddLink,
+	"ListLinks": (*Server).grpcListLinks,
+	"AddPeers":  (*Server).grpcAddPeers,
+	"ListPeers": (*Server).grpcListPeers,
+}
+
+// grpcProtocols returns the HTTP protocols that gRPC uses: HTTP/2 without
+// TLS.
+func grpcProtocols() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// serveGRPC handles a gRPC call.
+func (s *Server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !strings.HasPrefix(r.Header.Get("content-type"), "application/grpc") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("content-type", "application/grpc")
+	// The status is sent in trailers, so the HTTP status is always OK.
+	w.WriteHeader(http.StatusOK)
+
+	service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if service != grpcService {
+		writeGRPCStatus(w, &grpcError{grpcUnimplemented, fmt.Sprintf("unknown service %q", service)})
+		return
+	}
+	if method == "StreamLinks" {
+		s.grpcStreamLinks(w, r)
+		return
+	}
+	fn, present := grpcMethods[method]
+	if !present {
+		writeGRPCStatus(w, &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %q", method)})
+		return
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err == nil {
+		var resp []byte
+		if resp, err = fn(s, r, req); err == nil {
+			err = writeGRPCMessage(w, resp)
+		}
+	}
+	writeGRPCStatus(w, err)
+}
+
+// readGRPCMessage reads one length-prefixed gRPC message from r.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading request message: %s", err)}
+	}
+	if hdr[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxGRPCMessage {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("request message is too large (max %d bytes)", maxGRPCMessage)}
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("reading request message: %s", err)}
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes one length-prefixed gRPC message to w.
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	_, err := w.Write(append(hdr[:], msg...))
+	return err
+}
+
+// writeGRPCStatus writes the status of a gRPC call for err (which is OK if
+// err is nil) in the response trailers.
+func writeGRPCStatus(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		var ge *grpcError
+		if !errors.As(err, &ge) {
+			ge = &grpcError{grpcInternal, err.Error()}
+		}
+		code, msg = ge.code, ge.msg
+	}
+	w.Header().Set(http.TrailerPrefix+"grpc-status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"grpc-message", grpcPercentEncode(msg))
+	}
+}
+
+// grpcPercentEncode encodes a grpc-message trailer value, which must be
+// percent-encoded UTF-8.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// submitErrorGRPC returns the gRPC error for an error returned by
+// submitLink.
+func submitErrorGRPC(err error) error {
+	switch err {
+	case errLinkDeleted:
+		return &grpcError{grpcFailedPrecondition, err.Error()}
+	case errShuttingDown:
+		return &grpcError{grpcUnavailable, err.Error()}
+	}
+	return &grpcError{grpcInvalidArgument, err.Error()}
+}
+
+func (s *Server) grpcAddLink(r *http.Request, req []byte) ([]byte, error) {
+	var l *link
+	err := readFields(req, func(field int, v []byte) (err error) {
+		if field == 1 {
+			l, err = unmarshalProtoLink(v)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if l == nil {
+		return nil, &grpcError{grpcInvalidArgument, "no url"}
+	}
+	setOrigin(r, l)
+	result, err := s.submitLink(l)
+	if err != nil {
+		return nil, submitErrorGRPC(err)
+	}
+	return appendString(nil, 1, string(result)), nil
+}
+
+func (s *Server) grpcListLinks(r *http.Request, req []byte) ([]byte, error) {
+	var resp []byte
+	for _, l := range s.listLinks() {
+		resp = appendField(resp, 1, l.marshalProto())
+	}
+	return resp, nil
+}
+
+func (s *Server) grpcAddPeers(r *http.Request, req []byte) ([]byte, error) {
+	if !s.isAdmin(r) {
+		return nil, &grpcError{grpcUnauthenticated, "missing or invalid admin token"}
+	}
+	hosts, err := readStrings(req, 1)
+	if err != nil {
+		return nil, &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	s.addPeerHosts(hosts)
+	return nil, nil
+}
+
+func (s *Server) grpcListPeers(r *http.Request, req []byte) ([]byte, error) {
+	return appendStrings(nil, 1, s.peerHosts()), nil
+}
+
+// grpcStreamLinks handles the StreamLinks call, which streams link events
+// like GET /events.
+func (s *Server) grpcStreamLinks(w http.ResponseWriter, r *http.Request) {
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, err)
+		return
+	}
+	ids, err := readStrings(req, 1)
+	if err != nil {
+		writeGRPCStatus(w, &grpcError{grpcInvalidArgument, err.Error()})
+		return
+	}
+	var lastID string
+	if len(ids) > 0 {
+		lastID = ids[len(ids)-1]
+	}
+
+	c, missed, ok := s.events.subscribe(lastID)
+	defer s.events.unsubscribe(c)
+	if !ok {
+		missed = append([]event{{Type: "reset"}}, missed...)
+	}
+	rc := http.NewResponseController(w)
+	for _, e := range missed {
+		if err := writeGRPCMessage(w, e.marshalProto()); err != nil {
+			return
+		}
+	}
+	rc.Flush()
+
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				// We fell behind; the client can resume from its last event.
+				writeGRPCStatus(w, &grpcError{grpcUnavailable, "fell behind; reconnect to resume"})
+				return
+			}
+			if err := writeGRPCMessage(w, e.marshalProto()); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startGRPCServer starts a test gRPC server for s and returns a function
+// that calls its methods.
+func startGRPCServer(t *testing.T, s *Server) (call func(ctx context.Context, method string, req []byte) *http.Response, close func()) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(s.serveGRPC))
+	server.Config.Protocols = grpcProtocols()
+	server.Start()
+	client := &http.Client{Transport: &http.Transport{Protocols: grpcProtocols()}}
+	call = func(ctx context.Context, method string, req []byte) *http.Response {
+		var body bytes.Buffer
+		writeGRPCMessage(&body, req)
+		r, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/"+grpcService+"/"+method, &body)
+		r.Header.Set("content-type", "application/grpc")
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	return call, server.Close
+}
+
+// grpcUnary calls a unary method and returns its response message and
+// status.
+func grpcUnary(t *testing.T, call func(context.Context, string, []byte) *http.Response, method string, req []byte) ([]byte, string) {
+	resp := call(context.Background(), method, req)
+	defer resp.Body.Close()
+	msg, err := readGRPCMessage(resp.Body)
+	if err != nil {
+		msg = nil
+	}
+	io.Copy(io.Discard, resp.Body) // trailers are available after the body
+	return msg, resp.Trailer.Get("grpc-status")
+}
+
+// TestGRPC tests the unary methods of the gRPC API.
+func TestGRPC(t *testing.T) {
+	s := newTestServer(t)
+	call, closeServer := startGRPCServer(t, s)
+	defer closeServer()
+
+	l := &link{URL: "http://grpc.example.com/", Title: "gRPC", Tags: []string{"rpc"}}
+	resp, status := grpcUnary(t, call, "AddLink", appendField(nil, 1, l.marshalProto()))
+	if status != "0" {
+		t.Fatalf("AddLink: got grpc-status %s, want 0", status)
+	}
+	if got, _ := readStrings(resp, 1); len(got) != 1 || got[0] != string(submitAdded) {
+		t.Errorf("AddLink: got status %q, want %q", got, submitAdded)
+	}
+
+	resp, _ = grpcUnary(t, call, "ListLinks", nil)
+	var found bool
+	readFields(resp, func(field int, v []byte) error {
+		if got, _ := unmarshalProtoLink(v); got.URL == l.URL {
+			found = got.Title == l.Title && len(got.Tags) == 1 && got.Tags[0] == "rpc"
+		}
+		return nil
+	})
+	if !found {
+		t.Errorf("ListLinks: want link %+v in response", l)
+	}
+
+	bad := &link{URL: "ftp://grpc.example.com/"}
+	if _, status := grpcUnary(t, call, "AddLink", appendField(nil, 1, bad.marshalProto())); status != "3" {
+		t.Errorf("AddLink with bad URL: got grpc-status %s, want 3 (INVALID_ARGUMENT)", status)
+	}
+
+	if _, status := grpcUnary(t, call, "AddPeers", appendStrings(nil, 1, []string{"grpc.example.com:7000"})); status != "0" {
+		t.Errorf("AddPeers: got grpc-status %s, want 0", status)
+	}
+	s.AddPeers("b.example.com:1", "a.example.com:1")
+	resp, _ = grpcUnary(t, call, "ListPeers", nil)
+	if got, _ := readStrings(resp, 1); len(got) != 3 || got[0] != "a.example.com:1" || got[2] != "grpc.example.com:7000" {
+		t.Errorf("ListPeers: got %q, want sorted peers", got)
+	}
+
+	if _, status := grpcUnary(t, call, "Nope", nil); status != "12" {
+		t.Errorf("unknown method: got grpc-status %s, want 12 (UNIMPLEMENTED)", status)
+	}
+}
+
+// TestGRPC_StreamLinks tests that StreamLinks streams link events.
+func TestGRPC_StreamLinks(t *testing.T) {
+	s := newTestServer(t)
+	call, closeServer := startGRPCServer(t, s)
+	defer closeServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp := call(ctx, "StreamLinks", appendString(nil, 1, s.events.lastID()))
+	defer resp.Body.Close()
+
+	doRequest(s, "POST", "/links", `{"URL":"http://grpc.example.com/stream","Title":"Streamed"}`)
+	msgc := make(chan []byte, 1)
+	go func() {
+		msg, _ := readGRPCMessage(resp.Body)
+		msgc <- msg
+	}()
+	select {
+	case msg := <-msgc:
+		var typ, url string
+		readFields(msg, func(field int, v []byte) error {
+			switch field {
+			case 2:
+				typ = string(v)
+			case 4:
+				l, _ := unmarshalProtoLink(v)
+				url = l.URL
+			}
+			return nil
+		})
+		if typ != linkAdded || url != "http://grpc.example.com/stream" {
+			t.Errorf("got event %q for %q, want %q for the added link", typ, url, linkAdded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+// TestGRPC_Framing tests the length prefix of gRPC messages.
+func TestGRPC_Framing(t *testing.T) {
+	var b strings.Builder
+	writeGRPCMessage(&b, []byte(goldenAddLinkResponse))
+	testGolden(t, "framed AddLinkResponse", []byte(b.String()), "\x00\x00\x00\x00\x07"+goldenAddLinkResponse)
+
+	msg, err := readGRPCMessage(strings.NewReader(b.String()))
+	if err != nil || string(msg) != goldenAddLinkResponse {
+		t.Errorf("got %q, %v, want %q", msg, err, goldenAddLinkResponse)
+	}
+	if _, err := readGRPCMessage(strings.NewReader("\x01\x00\x00\x00\x00")); err == nil {
+		t.Error("reading compressed message: got no error, want error")
+	}
+}
+
+// TestGRPC_Status tests that the status of a call is sent in the
+// grpc-status and grpc-message trailers, with the message percent-encoded.
+func TestGRPC_Status(t *testing.T) {
+	for _, test := range []struct {
+		err         error
+		wantStatus  string
+		wantMessage string
+	}{
+		{nil, "0", ""},
+		{&grpcError{grpcInvalidArgument, "bad 100% café\n"}, "3", "bad 100%25 caf%C3%A9%0A"},
+		{errors.New("oops"), "13", "oops"},
+	} {
+		rec := httptest.NewRecorder()
+		writeGRPCStatus(rec, test.err)
+		trailer := rec.Result().Trailer
+		if got := trailer.Get("grpc-status"); got != test.wantStatus {
+			t.Errorf("%v: got grpc-status %q, want %q", test.err, got, test.wantStatus)
+		}
+		if got := trailer.Get("grpc-message"); got != test.wantMessage {
+			t.Errorf("%v: got grpc-message %q, want %q", test.err, got, test.wantMessage)
+		}
+	}
+
+	// Over HTTP/2, the HTTP status is OK and the gRPC status is in trailers.
+	s := newTestServer(t)
+	call, closeServer := startGRPCServer(t, s)
+	defer closeServer()
+	_, status := grpcUnary(t, call, "AddLink", []byte("\x0a\x05\x0a\x03ftp"))
+	if status != "3" {
+		t.Errorf("AddLink with bad URL: got grpc-status %s, want 3 (INVALID_ARGUMENT)", status)
+	}
+	resp := call(t.Context(), "Nope", nil)
+	defer resp.Body.Close()
+	readGRPCMessage(resp.Body) // trailers are available after the body
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("content-type") != "application/grpc" {
+		t.Errorf("got HTTP status %d, content-type %q, want 200 and application/grpc", resp.StatusCode, resp.Header.Get("content-type"))
+	}
+	if got, want := resp.Trailer.Get("grpc-status"), "12"; got != want {
+		t.Errorf("unknown method: got grpc-status %q, want %q", got, want)
+	}
+	if got, want := resp.Trailer.Get("grpc-message"), `unknown method "Nope"`; got != want {
+		t.Errorf("unknown method: got grpc-message %q, want %q", got, want)
+	}
+}
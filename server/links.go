@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// link is a shared link. It is also the JSON format used by the /links
+// endpoints and when broadcasting to peers.
+type link struct {
+	URL   string
+	Title string   `json:",omitempty"`
+	Tags  []string `json:",omitempty"`
+
+	// requestID is the ID of the request that submitted the link (see
+	// requestIDHeader), which is logged and sent on with broadcasts.
+	requestID string
+
+	// trace is the trace context of the request that submitted the link,
+	// which the spans for fetching its title and broadcasting it continue.
+	trace spanContext
+
+	// State is the link's replicated state (see package crdt), which peers
+	// merge into their own to learn about edits and deletions. It is omitted
+	// for links that have only been added, so that broadcasts of new links
+	// are still understood by peers that only know about URL and Title.
+	State *crdt.Entry[crdt.Link] `json:",omitempty"`
+
+	// Added is when this server first saw the link. It is local to each
+	// server and not replicated.
+	Added time.Time `json:"-"`
+}
+
+// deleted reports whether l is a tombstone for a deleted link.
+func (l *link) deleted() bool {
+	return l.State != nil && !l.State.Present()
+}
+
+// entry returns l's replicated state. A link without State is treated as
+// having been added with the zero tag, which every server that receives the
+// link adds identically. That way, a stale copy of a link that is
+// re-broadcasted after the link was deleted is recognized as already
+// deleted.
+func (l *link) entry() crdt.Entry[crdt.Link] {
+	if l.State != nil {
+		return *l.State
+	}
+	return crdt.Entry[crdt.Link]{
+		Adds: []crdt.Timestamp{{}},
+		Value: crdt.Link{
+			Title: crdt.Register[string]{Value: l.Title},
+			Tags:  crdt.Register[[]string]{Value: l.Tags},
+		},
+	}
+}
+
+// linkFromEntry returns the link with the given URL and replicated state.
+func (s *Server) linkFromEntry(url string, e crdt.Entry[crdt.Link]) *link {
+	l := &link{URL: url, Title: e.Value.Title.Value, Tags: e.Value.Tags.Value}
+	s.addedMu.Lock()
+	l.Added = s.added[url]
+	s.addedMu.Unlock()
+	if !isInitial(e) {
+		l.State = &e
+	}
+	return l
+}
+
+// isInitial reports whether e is the state of a link that has only been
+// added (and not edited or deleted).
+func isInitial(e crdt.Entry[crdt.Link]) bool {
+	return len(e.Adds) == 1 && e.Adds[0].IsZero() && len(e.Removes) == 0 &&
+		e.Value.Title.Time.IsZero() && e.Value.Tags.Time.IsZero()
+}
+
+// LinkID returns the ID of the link with the given URL, as used in the
+// /links/{id} endpoints. IDs are derived from the URL so that every server
+// agrees on them without coordination.
+func LinkID(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// newNodeID returns a random ID to distinguish this server's timestamps
+// from its peers'.
+func newNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// getLink returns the link with the given ID (which may be deleted), or nil
+// if there is none.
+func (s *Server) getLink(id string) *link {
+	s.addedMu.Lock()
+	url, ok := s.urls[id]
+	s.addedMu.Unlock()
+	if !ok {
+		return nil
+	}
+	e, _ := s.links.Get(url)
+	return s.linkFromEntry(url, e)
+}
+
+// mergeLink merges l into our copy of the link. It returns the merged link,
+// and whether the merge changed our copy.
+func (s *Server) mergeLink(l *link) (*link, bool) {
+	merged, changed := s.mergeLinks([]*link{l})
+	return merged[0], changed[0]
+}
+
+// mergeLinks merges each of ls into our copy of the link, all at once:
+// other operations on our links see either none of the merges or all of
+// them. It returns the merged links, and whether each merge changed our
+// copy.
+func (s *Server) mergeLinks(ls []*link) ([]*link, []bool) {
+	keys := make([]string, len(ls))
+	es := make([]crdt.Entry[crdt.Link], len(ls))
+	for i, l := range ls {
+		keys[i], es[i] = l.URL, l.entry()
+		for _, t := range es[i].Adds {
+			s.clock.Update(t)
+		}
+		s.clock.Update(es[i].Value.Title.Time)
+		s.clock.Update(es[i].Value.Tags.Time)
+	}
+	results := s.links.MergeAll(keys, es)
+
+	merged, changed := make([]*link, len(ls)), make([]bool, len(ls))
+	for i, r := range results {
+		l := ls[i]
+		if r.Changed {
+			s.addedMu.Lock()
+			if _, present := s.added[l.URL]; !present {
+				t := l.Added
+				if t.IsZero() {
+					t = s.opts.Clock.Now()
+				}
+				s.added[l.URL] = t
+				s.urls[LinkID(l.URL)] = l.URL
+			}
+			s.addedMu.Unlock()
+			s.publishChange(l.URL, r.Old, r.Merged)
+		}
+		merged[i], changed[i] = s.linkFromEntry(l.URL, r.Merged), r.Changed
+	}
+	return merged, changed
+}
+
+// listLinks returns all links that aren't deleted, in the order they were
+// added.
+func (s *Server) listLinks() []*link {
+	var ls []*link
+	for _, url := range s.links.Keys() {
+		if e, _ := s.links.Get(url); e.Present() {
+			ls = append(ls, s.linkFromEntry(url, e))
+		}
+	}
+	return ls
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// startFakePeer starts a test server that sends every link POSTed to its
+// /links endpoint on the returned channel, and adds it to s's peers.
+func startFakePeer(t *testing.T, s *Server) (received <-chan *link, close func()) {
+	c := make(chan *link, 10)
+	fakeMux := http.NewServeMux()
+	fakeMux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		var l *link
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			t.Errorf("fake peer: bad JSON: %s", err)
+			return
+		}
+		c <- l
+	})
+	fakeServer := httptest.NewServer(fakeMux)
+	fakeServerURL, _ := url.Parse(fakeServer.URL)
+	s.AddPeers(fakeServerURL.Host)
+	return c, fakeServer.Close
+}
+
+func waitForLink(t *testing.T, received <-chan *link) *link {
+	select {
+	case l := <-received:
+		return l
+	case <-time.After(time.Second):
+		t.Fatal("fake peer did not receive broadcasted link")
+		return nil
+	}
+}
+
+func doRequest(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	s.Handler().ServeHTTP(resp, req)
+	return resp
+}
+
+// TestDeleteLink tests that deleting a link removes it from the homepage,
+// broadcasts a tombstone to peers, and prevents stale copies of the link
+// from resurrecting it.
+func TestDeleteLink(t *testing.T) {
+	s := newTestServer(t)
+	received, closeFakePeer := startFakePeer(t, s)
+	defer closeFakePeer()
+
+	resp := doRequest(s, "POST", "/links", `{"URL":"http://example.com/spam","Title":"Spam"}`)
+	testStatusCode(t, "after adding a link", resp.Code, http.StatusOK)
+	waitForLink(t, received)
+
+	resp = doRequest(s, "DELETE", "/links/"+LinkID("http://example.com/spam"), "")
+	testStatusCode(t, "after deleting a link", resp.Code, http.StatusOK)
+	if l := waitForLink(t, received); !l.deleted() {
+		t.Errorf("got broadcasted link %+v, want tombstone", l)
+	}
+
+	if body := doRequest(s, "GET", "/", "").Body.String(); strings.Contains(body, "Spam") {
+		t.Errorf(`want "Spam" to not appear on homepage after deleting it, got %q`, body)
+	}
+
+	// A peer re-broadcasting the original link must not resurrect it.
+	resp = doRequest(s, "POST", "/links", `{"URL":"http://example.com/spam","Title":"Spam"}`)
+	testStatusCode(t, "after re-adding a deleted link", resp.Code, http.StatusGone)
+	if body := doRequest(s, "GET", "/", "").Body.String(); strings.Contains(body, "Spam") {
+		t.Errorf(`want "Spam" to not appear on homepage after re-adding it, got %q`, body)
+	}
+}
+
+// TestEditLink tests that editing a link's title and tags updates the
+// homepage and broadcasts the new version to peers.
+func TestEditLink(t *testing.T) {
+	s := newTestServer(t)
+	received, closeFakePeer := startFakePeer(t, s)
+	defer closeFakePeer()
+
+	resp := doRequest(s, "POST", "/links", `{"URL":"http://example.com/edit","Title":"Before"}`)
+	testStatusCode(t, "after adding a link", resp.Code, http.StatusOK)
+	waitForLink(t, received)
+
+	resp = doRequest(s, "PATCH", "/links/"+LinkID("http://example.com/edit"), `{"Title":"After","Tags":["go"]}`)
+	testStatusCode(t, "after editing a link", resp.Code, http.StatusOK)
+	if l := waitForLink(t, received); l.Title != "After" || len(l.Tags) != 1 || l.State == nil {
+		t.Errorf("got broadcasted link %+v, want edited link with state", l)
+	}
+
+	if body := doRequest(s, "GET", "/", "").Body.String(); !strings.Contains(body, "After") {
+		t.Errorf(`want "After" to appear on homepage after editing, got %q`, body)
+	}
+}
+
+// TestAddLink_LastWriterWins tests that an edit received from a peer only
+// replaces our copy of the link if it is newer.
+func TestAddLink_LastWriterWins(t *testing.T) {
+	s := newTestServer(t)
+	postEdit := func(title string, wall int64) {
+		e := (&link{URL: "http://example.com/lww", Title: "v0"}).entry()
+		e.Value.Title = e.Value.Title.Set(title, crdt.Timestamp{Wall: wall, Node: "peer"})
+		body, _ := json.Marshal(&link{URL: "http://example.com/lww", Title: title, State: &e})
+		resp := doRequest(s, "POST", "/links", string(body))
+		testStatusCode(t, "after adding an edited link", resp.Code, http.StatusOK)
+	}
+
+	postEdit("v2", 2)
+	postEdit("v1", 1)
+	if l := s.getLink(LinkID("http://example.com/lww")); l == nil || l.Title != "v2" {
+		t.Errorf("got link %+v, want title v2", l)
+	}
+
+	postEdit("v3", 3)
+	if l := s.getLink(LinkID("http://example.com/lww")); l == nil || l.Title != "v3" {
+		t.Errorf("got link %+v, want title v3", l)
+	}
+}
+
+// TestServeLink_NotFound tests that deleting or editing an unknown link
+// fails.
+func TestServeLink_NotFound(t *testing.T) {
+	s := newTestServer(t)
+	resp := doRequest(s, "DELETE", "/links/doesnotexist", "")
+	testStatusCode(t, "after deleting an unknown link", resp.Code, http.StatusNotFound)
+	resp = doRequest(s, "PATCH", "/links/doesnotexist", `{"Title":"x"}`)
+	testStatusCode(t, "after editing an unknown link", resp.Code, http.StatusNotFound)
+}
+
+// TestGetLink tests that links are found by ID, including deleted links.
+func TestGetLink(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 10; i++ {
+		s.mergeLink(&link{URL: fmt.Sprintf("http://example.com/%d", i), Title: "t"})
+	}
+	doRequest(s, "DELETE", "/links/"+LinkID("http://example.com/3"), "")
+	for i := 0; i < 10; i++ {
+		url := fmt.Sprintf("http://example.com/%d", i)
+		if l := s.getLink(LinkID(url)); l == nil || l.URL != url {
+			t.Errorf("getLink(LinkID(%q)): got %+v", url, l)
+		}
+	}
+	if l := s.getLink(LinkID("http://example.com/unknown")); l != nil {
+		t.Errorf("got link %+v for unknown ID, want nil", l)
+	}
+}
+
+// TestServeLink_AdminToken tests that editing and deleting links requires
+// the admin token when one is set.
+func TestServeLink_AdminToken(t *testing.T) {
+	s := New(Options{AdminToken: "secret"})
+	t.Cleanup(s.stop)
+	s.mergeLink(&link{URL: "http://example.com/admin", Title: "Admin"})
+	path := "/links/" + LinkID("http://example.com/admin")
+	for _, method := range []string{"PATCH", "DELETE"} {
+		resp := doRequest(s, method, path, `{"Title":"Edited"}`)
+		testStatusCode(t, method+" without the token", resp.Code, http.StatusUnauthorized)
+	}
+	if l := s.getLink(LinkID("http://example.com/admin")); l.Title != "Admin" || l.deleted() {
+		t.Fatalf("got link %+v, want it unchanged", l)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "DELETE with the token", resp.Code, http.StatusOK)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is the HTTP header (and gRPC metadata key) that carries
+// the ID of the request that submitted a link. It is sent with the link's
+// title fetch and with every broadcast of the link to peers (which log it
+// and send it on to their peers), so that a link can be traced across
+// servers. A batch of links carries a comma-separated list of IDs, one per
+// link.
+const requestIDHeader = "X-Request-Id"
+
+// newRequestID returns a new random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDs returns the request IDs in r's header (which has one ID, or
+// one per link for batches).
+func requestIDs(r *http.Request) []string {
+	v := r.Header.Get(requestIDHeader)
+	if v == "" {
+		return nil
+	}
+	ids := strings.Split(v, ",")
+	for i := range ids {
+		ids[i] = strings.TrimSpace(ids[i])
+	}
+	return ids
+}
+
+// setOrigin records that the links in ls were submitted by r: it sets their
+// request IDs from r's header (which has either one ID per link or a single
+// ID for all links) and their trace context from r's context.
+func setOrigin(r *http.Request, ls ...*link) {
+	ids := requestIDs(r)
+	sc := spanContextFrom(r.Context())
+	for i, l := range ls {
+		switch {
+		case len(ids) == len(ls):
+			l.requestID = ids[i]
+		case len(ids) > 0:
+			l.requestID = ids[0]
+		}
+		l.trace = sc
+	}
+}
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush and set deadlines on the
+// underlying ResponseWriter.
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Flush implements http.Flusher, which handlers that stream check for.
+func (w *statusRecorder) Flush() { http.NewResponseController(w.ResponseWriter).Flush() }
+
+// logRequests wraps h to log each request, and to give each request an ID
+// (unless the client or peer sent one) in its requestIDHeader, which is
+// also sent in the response.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(requestIDHeader) == "" {
+			r.Header.Set(requestIDHeader, newRequestID())
+		}
+		w.Header().Set(requestIDHeader, r.Header.Get(requestIDHeader))
+		rec := &statusRecorder{ResponseWriter: w}
+		t0 := time.Now()
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.Debug("Request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"duration", time.Since(t0), "remote", r.RemoteAddr, "request_id", r.Header.Get(requestIDHeader))
+	})
+}
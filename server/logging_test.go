@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequestID tests that the request ID of POST /links is sent with the
+// title fetch and the broadcast to peers.
+func TestRequestID(t *testing.T) {
+	s := newTestServer(t)
+	fetchIDs, peerIDs := make(chan string, 1), make(chan string, 1)
+	fakeTitleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetchIDs <- r.Header.Get(requestIDHeader)
+		w.Write([]byte(`<title>Traced</title>`))
+	}))
+	defer fakeTitleServer.Close()
+	fakePeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerIDs <- r.Header.Get(requestIDHeader)
+	}))
+	defer fakePeer.Close()
+	fakePeerURL, _ := url.Parse(fakePeer.URL)
+	s.AddPeers(fakePeerURL.Host)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"`+fakeTitleServer.URL+`/traced"}`))
+	req.Header.Set(requestIDHeader, "trace-1")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "adding a link with a request ID", resp.Code, http.StatusOK)
+	if got := resp.Header().Get(requestIDHeader); got != "trace-1" {
+		t.Errorf("got response request ID %q, want trace-1", got)
+	}
+
+	for what, c := range map[string]chan string{"title fetch": fetchIDs, "broadcast": peerIDs} {
+		select {
+		case id := <-c:
+			if id != "trace-1" {
+				t.Errorf("%s: got request ID %q, want trace-1", what, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: no request received", what)
+		}
+	}
+}
+
+// TestLogRequests_NewID tests that requests without an ID are given one.
+func TestLogRequests_NewID(t *testing.T) {
+	s := newTestServer(t)
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	s.Handler().ServeHTTP(resp, req)
+	if resp.Header().Get(requestIDHeader) == "" {
+		t.Error("got no request ID in response")
+	}
+}
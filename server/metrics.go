@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file exposes metrics at GET /metrics in the Prometheus text format
+// (see https://prometheus.io/docs/instrumenting/exposition_formats/).
+
+// metrics holds a server's metrics.
+type metrics struct {
+	linkSubmissions    *counter
+	titleFetches       *counter
+	titleFetchDuration *histogram
+	broadcasts         *counter
+	broadcastDuration  *histogram
+
+	// all holds all metrics, in the order they are written.
+	all []metric
+}
+
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		linkSubmissions: newCounter("gophurls_link_submissions_total",
+			"Links submitted (by users, peers or imports), by result.", "result"),
+
+		titleFetches: newCounter("gophurls_title_fetches_total",
+			"Title fetches, by outcome.", "outcome"),
+		titleFetchDuration: newHistogram("gophurls_title_fetch_duration_seconds",
+			"Time taken to fetch link titles, by outcome.", defaultBuckets, "outcome"),
+
+		broadcasts: newCounter("gophurls_broadcasts_total",
+			"Requests (or stream batches) sending links to peers, by peer and outcome.", "peer", "outcome"),
+		broadcastDuration: newHistogram("gophurls_broadcast_duration_seconds",
+			"Time taken for peers to accept links sent to them, by peer.", defaultBuckets, "peer"),
+	}
+	m.all = []metric{
+		m.linkSubmissions, m.titleFetches, m.titleFetchDuration, m.broadcasts, m.broadcastDuration,
+		newGaugeFunc("gophurls_links", "Links in the store, by state.", "state", func() map[string]float64 {
+			present, deleted := s.linkCounts()
+			return map[string]float64{"present": float64(present), "deleted": float64(deleted)}
+		}),
+		newGaugeFunc("gophurls_title_fetches_in_flight", "Title fetches currently running.", "", func() map[string]float64 {
+			inFlight, _ := s.fetchQueue()
+			return map[string]float64{"": float64(inFlight)}
+		}),
+		newGaugeFunc("gophurls_title_fetch_queue_depth", "Title fetches waiting to run.", "", func() map[string]float64 {
+			_, queued := s.fetchQueue()
+			return map[string]float64{"": float64(queued)}
+		}),
+		newGaugeFunc("gophurls_broadcast_queue_depth", "Links waiting to be sent to each peer.", "peer", func() map[string]float64 {
+			depths := make(map[string]float64)
+			for host, n := range s.peerQueueDepths() {
+				depths[host] = float64(n)
+			}
+			return depths
+		}),
+	}
+	return m
+}
+
+// defaultBuckets are the histogram buckets (in seconds) for latencies.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is a metric family that can be written in the text format.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// serveMetrics handles GET /metrics.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, m := range s.metrics.all {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// counter is a counter with zero or more labels.
+type counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by formatted label pairs
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	return &counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc increments the counter with the given label values.
+func (c *counter) inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues, "", "")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key]++
+}
+
+func (c *counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatValue(c.values[key]))
+	}
+}
+
+// histogram is a histogram with zero or more labels.
+type histogram struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries // keyed by label values, joined with "\xff"
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, labels: labels, series: make(map[string]*histogramSeries)}
+}
+
+// observe records a value in the histogram with the given label values.
+func (h *histogram) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, present := h.series[key]
+	if !present {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// observeSince records the time since t0 (in seconds) in the histogram.
+func (h *histogram) observeSince(t0 time.Time, labelValues ...string) {
+	h.observe(time.Since(t0).Seconds(), labelValues...)
+}
+
+func (h *histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		labels := formatLabels(h.labels, s.labelValues, "", "")
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// gaugeFunc is a gauge whose values are computed when metrics are written.
+// Its function returns the value for each value of its label (or for "", if
+// it has no label).
+type gaugeFunc struct {
+	name, help, label string
+	fn                func() map[string]float64
+}
+
+func newGaugeFunc(name, help, label string, fn func() map[string]float64) *gaugeFunc {
+	return &gaugeFunc{name: name, help: help, label: label, fn: fn}
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	values := g.fn()
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, lv := range sortedKeys(values) {
+		var labels string
+		if g.label != "" {
+			labels = formatLabels([]string{g.label}, []string{lv}, "", "")
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatValue(values[lv]))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels formats label pairs as `{name="value",...}`, with an extra
+// label (such as a histogram's "le") appended if extraName is not empty.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		fmt.Fprintf(&b, "%s=%s", name, quoteLabel(v))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", extraName, quoteLabel(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestMetrics tests that GET /metrics includes link submissions (including
+// imported ones) and the store size.
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	doRequest(s, "POST", "/links", `{"URL":"http://metrics.example.com/","Title":"Metrics"}`)
+	doRequest(s, "POST", "/import", `{"URL":"http://metrics.example.com/","Title":"Metrics"}`)
+
+	resp := doRequest(s, "GET", "/metrics", "")
+	testStatusCode(t, "metrics", resp.Code, http.StatusOK)
+	body := resp.Body.String()
+	for _, want := range []string{
+		"# TYPE gophurls_link_submissions_total counter\n",
+		`gophurls_link_submissions_total{result="added"} `,
+		`gophurls_link_submissions_total{result="duplicate"} `,
+		"# TYPE gophurls_title_fetch_duration_seconds histogram\n",
+		`gophurls_links{state="present"} `,
+		"gophurls_title_fetch_queue_depth 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in metrics, got %q", want, body)
+		}
+	}
+}
+
+// TestHistogram tests that histograms are written with cumulative buckets.
+func TestHistogram(t *testing.T) {
+	h := &histogram{name: "test_seconds", help: "Test.", buckets: []float64{1, 2}, labels: []string{"peer"}, series: make(map[string]*histogramSeries)}
+	h.observe(0.5, "a")
+	h.observe(1.5, "a")
+	h.observe(3, "a")
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	h.write(w)
+	w.Flush()
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{peer="a",le="1"} 1
+test_seconds_bucket{peer="a",le="2"} 2
+test_seconds_bucket{peer="a",le="+Inf"} 3
+test_seconds_sum{peer="a"} 5
+test_seconds_count{peer="a"} 3
+`
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAddPeer_OK tests that adding a peer succeeds, and that subsequently the
+// newly added peer is present in the set of peers.
+func TestAddPeer_OK(t *testing.T) {
+	s := newTestServer(t)
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/peers", strings.NewReader(`["example.com:1234"]`))
+	s.Handler().ServeHTTP(resp, req)
+
+	testStatusCode(t, "after adding peers", resp.Code, http.StatusOK)
+	if _, present := s.peers["example.com:1234"]; !present {
+		t.Errorf(`got peers %v, want member "example.com:1234"`, s.Peers())
+	}
+}
+
+// TestListPeers tests that GET /peers lists the peers, sorted.
+func TestListPeers(t *testing.T) {
+	s := newTestServer(t)
+	s.AddPeers("b.example.com:1", "a.example.com:1")
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/peers", nil)
+	s.Handler().ServeHTTP(resp, req)
+
+	testStatusCode(t, "listing peers", resp.Code, http.StatusOK)
+	if want := `["a.example.com:1","b.example.com:1"]` + "\n"; resp.Body.String() != want {
+		t.Errorf("got body %q, want %q", resp.Body.String(), want)
+	}
+}
+
+// TestRemovePeers tests that removed peers are no longer listed.
+func TestRemovePeers(t *testing.T) {
+	s := newTestServer(t)
+	s.AddPeers("a.example.com:1", "b.example.com:1")
+	s.RemovePeers("a.example.com:1", "c.example.com:1")
+	if got, want := s.Peers(), []string{"b.example.com:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got peers %v, want %v", got, want)
+	}
+}
+
+// TestAddPeer_AdminToken tests that adding peers requires the admin token
+// when one is set, but listing them does not.
+func TestAddPeer_AdminToken(t *testing.T) {
+	s := New(Options{AdminToken: "secret"})
+	t.Cleanup(s.stop)
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/peers", strings.NewReader(`["example.com:1234"]`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		s.Handler().ServeHTTP(resp, req)
+		testStatusCode(t, "adding peers with Authorization "+auth, resp.Code, http.StatusUnauthorized)
+	}
+	if peers := s.Peers(); len(peers) != 0 {
+		t.Errorf("got peers %v, want none added without the token", peers)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/peers", strings.NewReader(`["example.com:1234"]`))
+	req.Header.Set("Authorization", "Bearer secret")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "adding peers with the token", resp.Code, http.StatusOK)
+
+	resp = doRequest(s, "GET", "/peers", "")
+	testStatusCode(t, "listing peers without the token", resp.Code, http.StatusOK)
+}
+
+// TestBroadcast_Retry tests that links that a peer fails to receive
+// (because it's unavailable, as while it shuts down) are sent again, but
+// links that it rejects are not.
+func TestBroadcast_Retry(t *testing.T) {
+	s := newTestServer(t)
+	received := make(chan *link, 10)
+	var attempts atomic.Int32
+	fakePeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/links" {
+			http.NotFound(w, r) // so that batches are sent as single links
+			return
+		}
+		var l *link
+		json.NewDecoder(r.Body).Decode(&l)
+		switch {
+		case l.Title == "Rejected":
+			http.Error(w, "bad link", http.StatusBadRequest)
+		case attempts.Add(1) <= 2:
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		default:
+			received <- l
+		}
+	}))
+	defer fakePeer.Close()
+	fakePeerURL, _ := url.Parse(fakePeer.URL)
+	s.AddPeers(fakePeerURL.Host)
+
+	doRequest(s, "POST", "/links", `{"URL":"http://retry.example.com/rejected","Title":"Rejected"}`)
+	doRequest(s, "POST", "/links", `{"URL":"http://retry.example.com/retried","Title":"Retried"}`)
+	if l := waitForLink(t, received); l.Title != "Retried" {
+		t.Errorf("got link %+v, want Retried", l)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("got %d attempts to send the link, want 3", n)
+	}
+	for deadline := time.Now().Add(time.Second); s.pendingBroadcasts() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d broadcasts still pending, want the rejected link not to be retried", s.pendingBroadcasts())
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// addPeers handles POST /peers, which adds the peers in the JSON array of
+// "host:port" strings in the request body, and GET /peers, which lists
+// them.
+func (s *Server) addPeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(s.peerHosts())
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method must be GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !allowJSON(w, r) {
+		return
+	}
+	var hosts []string
+	if err := json.NewDecoder(r.Body).Decode(&hosts); err != nil {
+		http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+	s.addPeerHosts(hosts)
+}
+
+// broadcast sends ls to all peers by POSTing them to their /links
+// endpoints (or, with Options.Stream, on a stream to each peer). Links that
+// are broadcast together, or while a previous POST to a peer is in flight,
+// are sent together to its /links/batch endpoint. It does not wait for the
+// peers to respond.
+func (s *Server) broadcast(ls ...*link) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	for host := range s.peers {
+		if s.opts.Stream {
+			ps := s.streamTo(host)
+			for _, l := range ls {
+				ps.send(l)
+			}
+		} else {
+			s.senderTo(host).send(ls...)
+		}
+	}
+}
+
+// peerSender sends links to one peer with POST /links, or POST /links/batch
+// when more than one link is waiting. Links that fail to send (because the
+// peer is unreachable, or is shutting down) are retried with backoff.
+type peerSender struct {
+	srv     *Server
+	host    string
+	queue   chan *link
+	pending atomic.Int64 // links queued, being sent or waiting to be retried
+
+	// retry holds the links to send again once the backoff has passed (at
+	// most cap(queue) of them; the oldest are dropped beyond that). mu also
+	// guards queuing links, so that links queued together are taken from
+	// the queue together.
+	mu    sync.Mutex
+	retry []*link
+
+	// legacyUntil is when to next try POST /links/batch with a peer that
+	// didn't support it. It is only accessed by run.
+	legacyUntil time.Time
+}
+
+// senderTo returns the sender to the peer at host, starting it if needed.
+func (s *Server) senderTo(host string) *peerSender {
+	s.sendersMu.Lock()
+	defer s.sendersMu.Unlock()
+	ps, present := s.senders[host]
+	if !present {
+		ps = &peerSender{srv: s, host: host, queue: make(chan *link, 1024)}
+		s.senders[host] = ps
+		go ps.run()
+	}
+	return ps
+}
+
+// send queues ls to be sent to the peer (in one batch, if they fit). If the
+// queue is full (because the peer is unreachable or slow), links are
+// dropped.
+func (s *peerSender) send(ls ...*link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range ls {
+		s.pending.Add(1)
+		select {
+		case s.queue <- l:
+		default:
+			s.pending.Add(-1)
+			slog.Warn("Broadcast queue to peer is full; dropping link", "peer", s.host, "url", l.URL, "request_id", l.requestID)
+		}
+	}
+}
+
+// run sends queued links to the peer until the server shuts down. It
+// doesn't wait for links to accumulate, so a lone link is sent right away;
+// links queued while a send is in flight are sent in the next batch. Links
+// that failed are sent again (before any newly queued ones) once the
+// backoff has passed.
+func (s *peerSender) run() {
+	stopped := s.srv.stopped.Done()
+	backoff := minRetryBackoff
+	for {
+		s.mu.Lock()
+		n := min(len(s.retry), maxBatchSize)
+		ls := s.retry[:n:n]
+		s.retry = s.retry[n:]
+		s.mu.Unlock()
+		if len(ls) > 0 {
+			select {
+			case <-s.srv.opts.Clock.After(backoff):
+			case <-stopped:
+				s.requeue(ls) // to be saved at shutdown
+				return
+			}
+		} else {
+			select {
+			case l := <-s.queue:
+				ls = append(ls, l)
+			case <-stopped:
+				return
+			}
+		}
+		s.mu.Lock() // wait for the rest of the links queued with the first
+	drain:
+		for len(ls) < maxBatchSize {
+			select {
+			case l := <-s.queue:
+				ls = append(ls, l)
+			default:
+				break drain
+			}
+		}
+		s.mu.Unlock()
+
+		failed := s.sendLinks(ls)
+		s.pending.Add(-int64(len(ls) - len(failed)))
+		if len(failed) == 0 {
+			backoff = minRetryBackoff
+			continue
+		}
+		s.requeue(failed)
+		slog.Warn("Retrying links to peer", "peer", s.host, "links", len(failed), "retry_in", backoff)
+		if backoff *= 2; backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// requeue adds ls (which are still counted as pending) to the links to
+// retry, dropping the oldest if there are too many.
+func (s *peerSender) requeue(ls []*link) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retry = append(s.retry, ls...)
+	if drop := len(s.retry) - cap(s.queue); drop > 0 {
+		for _, l := range s.retry[:drop] {
+			slog.Warn("Retry queue to peer is full; dropping link", "peer", s.host, "url", l.URL, "request_id", l.requestID)
+		}
+		s.retry = s.retry[drop:]
+		s.pending.Add(-int64(drop))
+	}
+}
+
+// takeRetries removes and returns the links waiting to be retried.
+func (s *peerSender) takeRetries() []*link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls := s.retry
+	s.retry = nil
+	return ls
+}
+
+// sendLinks sends ls to the peer, as a batch if there is more than one and
+// the peer supports batches. It returns the links that failed to send and
+// should be retried.
+func (s *peerSender) sendLinks(ls []*link) (failed []*link) {
+	if len(ls) > 1 && s.srv.opts.Clock.Now().After(s.legacyUntil) {
+		span := s.srv.startSpan(ls[0].trace, "broadcast batch", spanClient)
+		span.setAttr("server.address", s.host)
+		span.setAttr("gophurls.links", len(ls))
+		for _, l := range ls[1:] {
+			span.addLink(l.trace)
+		}
+		err := s.srv.postBatch(s.host, ls, span.context(ls[0].trace))
+		span.finish(err)
+		if err == nil {
+			for _, l := range ls {
+				slog.Debug("Broadcasted link", "peer", s.host, "url", l.URL, "request_id", l.requestID)
+			}
+			return nil
+		}
+		if err != errBatchUnsupported {
+			slog.Error("Error broadcasting links", "peer", s.host, "links", len(ls), "err", err)
+			if retryable(err) {
+				return ls
+			}
+			return nil
+		}
+		slog.Info("Peer does not support batches; sending links with POST /links", "peer", s.host)
+		s.legacyUntil = s.srv.opts.Clock.Now().Add(legacyRecheckInterval)
+	}
+	for _, l := range ls {
+		if err := s.srv.sendLink(s.host, l); retryable(err) {
+			failed = append(failed, l)
+		}
+	}
+	return failed
+}
+
+// minRetryBackoff is how long a peerSender first waits before retrying
+// links that failed to send. It doubles after each failure, up to
+// maxStreamBackoff.
+const minRetryBackoff = 100 * time.Millisecond
+
+// statusError is an unexpected HTTP status in a peer's response.
+type statusError int
+
+func (code statusError) Error() string { return fmt.Sprintf("HTTP status %d", int(code)) }
+
+// retryable reports whether links that failed to send with err should be
+// sent again: if the peer couldn't be reached, or it failed or was
+// unavailable (as when it's shutting down), but not if it rejected them.
+func retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	code, ok := err.(statusError)
+	return !ok || code >= 500 || code == http.StatusTooManyRequests
+}
+
+// sendLink POSTs l to the /links endpoint of the peer at host.
+func (s *Server) sendLink(host string, l *link) error {
+	span := s.startSpan(l.trace, "broadcast", spanClient)
+	span.setAttr("server.address", host)
+	span.setAttr("url.full", l.URL)
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(l)
+	if err == nil {
+		err = s.postLink(host, buf.Bytes(), l.requestID, span.context(l.trace))
+	}
+	span.finish(err)
+	if err != nil {
+		slog.Error("Error broadcasting link", "peer", host, "url", l.URL, "err", err, "request_id", l.requestID)
+	} else {
+		slog.Debug("Broadcasted link", "peer", host, "url", l.URL, "request_id", l.requestID)
+	}
+	return err
+}
+
+// postLink POSTs the JSON-encoded link in body to the peer at host, with
+// the given request ID (if any) and trace context.
+func (s *Server) postLink(host string, body []byte, requestID string, trace spanContext) (err error) {
+	defer s.recordBroadcast(host, time.Now(), &err)
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/links", host), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	injectTrace(req.Header, trace)
+	resp, err := s.peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A peer that has already deleted the link responds with 410 Gone, which
+	// is expected when a deletion and a stale add cross paths.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusGone {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// recordBroadcast records the metrics for sending links to the peer at
+// host, which started at t0 and failed if *err is not nil.
+func (s *Server) recordBroadcast(host string, t0 time.Time, err *error) {
+	s.recordPeerResult(host, *err)
+	if *err != nil {
+		s.metrics.broadcasts.inc(host, "failure")
+		return
+	}
+	s.metrics.broadcasts.inc(host, "success")
+	s.metrics.broadcastDuration.observeSince(t0, host)
+}
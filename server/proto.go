@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// This file encodes and decodes the protocol buffer messages in
+// gophurls.proto. All fields are strings, repeated strings or messages, so
+// only length-delimited fields need to be handled; other fields are
+// skipped.
+
+// wireBytes is the protocol buffer wire type of length-delimited fields.
+const wireBytes = 2
+
+// appendField appends a length-delimited field to b.
+func appendField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendString appends a string field to b, unless s is empty (the default
+// value, which proto3 omits).
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendField(b, field, []byte(s))
+}
+
+var errBadProto = errors.New("malformed protocol buffer message")
+
+// readFields calls fn with the number and value of each length-delimited
+// field in the message b.
+func readFields(b []byte, fn func(field int, v []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errBadProto
+		}
+		b = b[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errBadProto
+			}
+		case 1: // 64-bit
+			n = 8
+		case 5: // 32-bit
+			n = 4
+		case wireBytes:
+			size, m := binary.Uvarint(b)
+			if m <= 0 || size > uint64(len(b)-m) {
+				return errBadProto
+			}
+			if err := fn(field, b[m:m+int(size)]); err != nil {
+				return err
+			}
+			n = m + int(size)
+		default:
+			return fmt.Errorf("unsupported protocol buffer wire type %d", wireType)
+		}
+		if n > len(b) {
+			return errBadProto
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// marshalProto encodes l as a Link message.
+func (l *link) marshalProto() []byte {
+	b := appendString(nil, 1, l.URL)
+	b = appendString(b, 2, l.Title)
+	for _, tag := range l.Tags {
+		b = appendField(b, 3, []byte(tag))
+	}
+	return b
+}
+
+// unmarshalProtoLink decodes a Link message.
+func unmarshalProtoLink(b []byte) (*link, error) {
+	l := new(link)
+	err := readFields(b, func(field int, v []byte) error {
+		switch field {
+		case 1:
+			l.URL = string(v)
+		case 2:
+			l.Title = string(v)
+		case 3:
+			l.Tags = append(l.Tags, string(v))
+		}
+		return nil
+	})
+	return l, err
+}
+
+// marshalProto encodes e as a LinkEvent message.
+func (e event) marshalProto() []byte {
+	b := appendString(nil, 1, e.ID)
+	b = appendString(b, 2, e.Type)
+	b = appendString(b, 3, e.LinkID)
+	if e.Link != nil {
+		b = appendField(b, 4, e.Link.marshalProto())
+	}
+	return b
+}
+
+// readStrings returns the values of all occurrences of the string field in
+// the message b (for a singular field, the last one is its value).
+func readStrings(b []byte, field int) ([]string, error) {
+	var ss []string
+	err := readFields(b, func(f int, v []byte) error {
+		if f == field {
+			ss = append(ss, string(v))
+		}
+		return nil
+	})
+	return ss, err
+}
+
+// appendStrings appends a repeated string field to b.
+func appendStrings(b []byte, field int, ss []string) []byte {
+	for _, s := range ss {
+		b = appendField(b, field, []byte(s))
+	}
+	return b
+}
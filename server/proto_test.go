@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The golden messages below are encoded by hand following
+// https://protobuf.dev/programming-guides/encoding/: each field is a varint
+// key (field number << 3 | wire type, so 0x0a is field 1, 0x12 field 2,
+// 0x1a field 3 and 0x22 field 4, all length-delimited), then a varint
+// length, then the bytes.
+const (
+	// Link{url: "http://a.co/", title: "A", tags: ["x", "yz"]}
+	goldenLink = "\x0a\x0chttp://a.co/" + "\x12\x01A" + "\x1a\x01x" + "\x1a\x02yz"
+
+	// Link{url: "http://b.co/", title: "B"}
+	goldenLink2 = "\x0a\x0chttp://b.co/" + "\x12\x01B"
+
+	// AddLinkRequest{link: <goldenLink>}
+	goldenAddLinkRequest = "\x0a\x18" + goldenLink
+
+	// AddLinkResponse{status: "added"}
+	goldenAddLinkResponse = "\x0a\x05added"
+
+	// ListLinksResponse{links: [<goldenLink>, <goldenLink2>]}
+	goldenListLinksResponse = "\x0a\x18" + goldenLink + "\x0a\x11" + goldenLink2
+
+	// StreamLinksRequest{last_event_id: "e-1"}
+	goldenStreamLinksRequest = "\x0a\x03e-1"
+
+	// LinkEvent{id: "e-2", type: "link-added", link_id: "abc", link: <goldenLink2>}
+	goldenLinkEvent = "\x0a\x03e-2" + "\x12\x0alink-added" + "\x1a\x03abc" + "\x22\x11" + goldenLink2
+
+	// AddPeersRequest{peers: ["a.example.com:1", "b.example.com:2"]}, which
+	// is encoded the same as ListPeersResponse with the same peers.
+	goldenPeers = "\x0a\x0fa.example.com:1" + "\x0a\x0fb.example.com:2"
+)
+
+var (
+	testLink  = &link{URL: "http://a.co/", Title: "A", Tags: []string{"x", "yz"}}
+	testLink2 = &link{URL: "http://b.co/", Title: "B"}
+)
+
+func testGolden(t *testing.T, name string, got []byte, want string) {
+	t.Helper()
+	if string(got) != want {
+		t.Errorf("%s: got bytes\n%q\nwant\n%q", name, got, want)
+	}
+}
+
+// TestProto_Golden tests that each message in gophurls.proto is encoded
+// (and decoded) as the protocol buffer encoding spec says.
+func TestProto_Golden(t *testing.T) {
+	s := newTestServer(t)
+	req := httptest.NewRequest("POST", "/"+grpcService+"/AddLink", nil)
+
+	testGolden(t, "Link", testLink.marshalProto(), goldenLink)
+	if l, err := unmarshalProtoLink([]byte(goldenLink)); err != nil || !reflect.DeepEqual(l, testLink) {
+		t.Errorf("decoding Link: got %+v, %v, want %+v", l, err, testLink)
+	}
+
+	resp, err := s.grpcAddLink(req, []byte(goldenAddLinkRequest))
+	if err != nil {
+		t.Fatalf("AddLink: %s", err)
+	}
+	testGolden(t, "AddLinkResponse", resp, goldenAddLinkResponse)
+	s.mergeLink(testLink2)
+
+	resp, _ = s.grpcListLinks(req, nil)
+	testGolden(t, "ListLinksResponse", resp, goldenListLinksResponse)
+
+	if ids, err := readStrings([]byte(goldenStreamLinksRequest), 1); err != nil || len(ids) != 1 || ids[0] != "e-1" {
+		t.Errorf("decoding StreamLinksRequest: got %q, %v, want [e-1]", ids, err)
+	}
+
+	e := event{ID: "e-2", Type: linkAdded, LinkID: "abc", Link: testLink2}
+	testGolden(t, "LinkEvent", e.marshalProto(), goldenLinkEvent)
+
+	if _, err := s.grpcAddPeers(req, []byte(goldenPeers)); err != nil {
+		t.Fatalf("AddPeers: %s", err)
+	}
+	resp, _ = s.grpcListPeers(req, nil)
+	testGolden(t, "ListPeersResponse", resp, goldenPeers)
+
+	// Empty messages (and fields with default values) encode to nothing.
+	testGolden(t, "Link{}", (&link{}).marshalProto(), "")
+	resp, _ = s.grpcAddPeers(req, nil)
+	testGolden(t, "AddPeersResponse", resp, "")
+}
+
+// TestProto_Varints tests lengths that need multi-byte varints, and that
+// fields of the wire types we don't use are skipped when decoding.
+func TestProto_Varints(t *testing.T) {
+	// 150 is 0x96 0x01, as in the encoding spec's example.
+	l := &link{URL: "http://a.co/", Title: strings.Repeat("t", 150)}
+	testGolden(t, "Link with 150-byte title", l.marshalProto(), "\x0a\x0chttp://a.co/\x12\x96\x01"+l.Title)
+
+	// Field 5 as a varint (150), field 6 as fixed64 and field 7 as fixed32.
+	msg := goldenLink + "\x28\x96\x01" + "\x31\x01\x02\x03\x04\x05\x06\x07\x08" + "\x3d\x01\x02\x03\x04"
+	if got, err := unmarshalProtoLink([]byte(msg)); err != nil || !reflect.DeepEqual(got, testLink) {
+		t.Errorf("decoding Link with unknown fields: got %+v, %v, want %+v", got, err, testLink)
+	}
+
+	for _, bad := range []string{"\x0a", "\x0a\x05abc", "\x0a\x96", "\x28", "\x31\x01", "\x0b"} {
+		if _, err := unmarshalProtoLink([]byte(bad)); err == nil {
+			t.Errorf("decoding %q: got no error, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+// Package server implements the GophURLs server, which shares links with
+// its users and replicates them to its peers.
+//
+// A Server holds all of its state (links, peers, queues and metrics), so a
+// program can embed one or more of them; the part3_network command is a thin
+// wrapper around a single Server.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// Options configures a Server.
+type Options struct {
+	// Addr is the HTTP service address that Start listens on. If empty,
+	// ":7000" is used.
+	Addr string
+
+	// GRPCAddr is the gRPC service address that Start listens on. If empty,
+	// the gRPC API is not served.
+	GRPCAddr string
+
+	// DataFile is the file to load links from in Start, and to save links
+	// (and undelivered broadcasts and unfinished title fetches) to in
+	// Shutdown. If empty, links are not saved.
+	DataFile string
+
+	// Stream is whether to send links to each peer over one persistent
+	// stream (falling back to POST /links for peers that don't support it).
+	Stream bool
+
+	// Peers holds the initial peers, in "host:port" format.
+	Peers []string
+
+	// NodeID distinguishes this server's timestamps from its peers'. If
+	// empty, a random ID is used.
+	NodeID string
+
+	// MaxFetches is the maximum number of title fetches that may run at
+	// once. If zero, 10 is used. FetchTimeout is how long a title fetch may
+	// take (if zero, fetches don't time out). Both can be changed while the
+	// server runs with SetLimits.
+	MaxFetches   int
+	FetchTimeout time.Duration
+
+	// AdminToken, if set, is the bearer token that requests that change or
+	// reveal the server's configuration (adding peers, importing links and
+	// GET /admin/status), or that edit or delete links (whose edits and
+	// tombstones replicate to every server), must carry in their
+	// Authorization header.
+	AdminToken string
+
+	// Transport, if set, carries the HTTP requests that the server makes:
+	// to its peers, and to fetch link titles. If nil, http.DefaultTransport
+	// is used. (Package sim uses it to connect servers over a simulated
+	// network.)
+	Transport http.RoundTripper
+
+	// Clock, if set, is the source of time for the server's timestamps and
+	// for waiting before retries. If nil, the system clock is used.
+	Clock Clock
+}
+
+// A Clock tells the time, and waits for time to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock used unless Options.Clock is set.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Server is a GophURLs server. Create Servers with New.
+type Server struct {
+	opts      Options
+	mux       *http.ServeMux
+	startTime time.Time
+
+	// ready is whether the server is ready to serve traffic: Start sets it
+	// once the store is loaded and the HTTP listener is up, and Shutdown
+	// clears it.
+	ready atomic.Bool
+
+	// draining is set when the server starts shutting down, after which new
+	// submissions are rejected.
+	draining atomic.Bool
+
+	// links holds all links (including deleted links) keyed by URL.
+	links *crdt.Set[crdt.Link]
+
+	// clock timestamps edits and deletions made on this server.
+	clock *crdt.Clock
+
+	// added holds the time each link (keyed by URL) was first seen, and
+	// urls holds the URL of each link (keyed by LinkID), so that getLink
+	// needn't hash every URL.
+	added   map[string]time.Time
+	urls    map[string]string
+	addedMu sync.Mutex
+
+	// peers holds the set of peer servers (in "host:port" format).
+	peers   map[string]struct{}
+	peersMu sync.Mutex
+
+	// senders holds the sender to each peer (keyed by host:port) that we
+	// have broadcasted to, and streams holds the stream to each peer when
+	// Options.Stream is set.
+	senders   map[string]*peerSender
+	sendersMu sync.Mutex
+	streams   map[string]*peerStream
+	streamsMu sync.Mutex
+
+	// peerResults holds the results of sending links to each peer.
+	peerResults   map[string]*peerState
+	peerResultsMu sync.Mutex
+
+	// fetchLimit rate-limits title fetches to Options.MaxFetches at a
+	// time, and fetchTimeout (a time.Duration) is Options.FetchTimeout.
+	fetchLimit   *limiter
+	fetchTimeout atomic.Int64
+
+	// fetching holds the links (keyed by ID) whose titles are currently
+	// being fetched, so that a link submitted many times is only fetched
+	// once.
+	fetching   map[string]*link
+	fetchingMu sync.Mutex
+
+	// peerClient sends links to peers, and client makes the server's other
+	// requests (title fetches, and peer streams, which mustn't time out).
+	// Both use Options.Transport.
+	peerClient, client *http.Client
+
+	events  *eventHub
+	metrics *metrics
+
+	// stopped is done once the server has shut down, which stops its
+	// senders and streams to peers.
+	stopped context.Context
+	stop    context.CancelFunc
+
+	// These are set by Start.
+	listener       net.Listener
+	servers        []*http.Server
+	cancelRequests context.CancelFunc
+	errc           chan error
+}
+
+// New returns a Server configured by opts. It doesn't listen or load any
+// links until Start is called, but its Handler can be used right away.
+func New(opts Options) *Server {
+	if opts.Addr == "" {
+		opts.Addr = ":7000"
+	}
+	if opts.NodeID == "" {
+		opts.NodeID = newNodeID()
+	}
+	if opts.MaxFetches <= 0 {
+		opts.MaxFetches = defaultMaxFetches
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	s := &Server{
+		opts:        opts,
+		mux:         http.NewServeMux(),
+		startTime:   time.Now(),
+		links:       crdt.NewSet[crdt.Link](),
+		clock:       crdt.NewClock(opts.NodeID, opts.Clock.Now),
+		added:       make(map[string]time.Time),
+		urls:        make(map[string]string),
+		peers:       make(map[string]struct{}),
+		senders:     make(map[string]*peerSender),
+		streams:     make(map[string]*peerStream),
+		peerResults: make(map[string]*peerState),
+		fetchLimit:  newLimiter(opts.MaxFetches),
+		fetching:    make(map[string]*link),
+		peerClient:  &http.Client{Timeout: 10 * time.Second, Transport: opts.Transport},
+		client:      &http.Client{Transport: opts.Transport},
+		events:      newEventHub(),
+		errc:        make(chan error, 2),
+	}
+	s.metrics = newMetrics(s)
+	s.fetchTimeout.Store(int64(opts.FetchTimeout))
+	s.stopped, s.stop = context.WithCancel(context.Background())
+	s.AddPeers(opts.Peers...)
+
+	s.mux.HandleFunc("/", s.home)
+	s.mux.HandleFunc("/links", s.postLinks)
+	s.mux.HandleFunc("/links/batch", s.postLinksBatch)
+	s.mux.HandleFunc("/links/", s.requireAdmin(s.serveLink, "DELETE", "PATCH"))
+	s.mux.HandleFunc("/peers", s.requireAdmin(s.addPeers, "POST"))
+	s.mux.HandleFunc("/peers/stream", s.servePeerStream)
+	s.mux.HandleFunc("/feed.rss", s.serveRSS)
+	s.mux.HandleFunc("/feed.atom", s.serveAtom)
+	s.mux.HandleFunc("/export", s.serveExport)
+	s.mux.HandleFunc("/import", s.requireAdmin(s.serveImport))
+	s.mux.HandleFunc("/share", serveShare)
+	s.mux.HandleFunc("/events", s.serveEvents)
+	s.mux.HandleFunc("/metrics", s.serveMetrics)
+	s.mux.HandleFunc("/healthz", serveHealthz)
+	s.mux.HandleFunc("/readyz", s.serveReadyz)
+	s.mux.HandleFunc("/admin/status", s.requireAdmin(s.serveAdminStatus))
+	s.mux.Handle("/static/", http.FileServer(http.FS(assets)))
+	return s
+}
+
+// Handler returns the server's HTTP handler, which logs and traces each
+// request. Start serves it on Options.Addr; programs that embed the server
+// in their own HTTP server can serve it themselves instead.
+func (s *Server) Handler() http.Handler {
+	return logRequests(s.traceRequests(s.mux))
+}
+
+// Start loads the links in Options.DataFile (if set) and starts serving
+// HTTP on Options.Addr (and gRPC on Options.GRPCAddr, if set). It returns
+// once the server is listening; errors that stop it serving after that are
+// sent on Err.
+func (s *Server) Start() error {
+	if s.listener != nil {
+		return errors.New("server already started")
+	}
+	if s.opts.DataFile != "" {
+		if err := s.loadSnapshot(s.opts.DataFile); err != nil {
+			return fmt.Errorf("loading links from %s: %s", s.opts.DataFile, err)
+		}
+	}
+
+	// Requests' contexts are canceled when shutting down, which ends
+	// long-lived requests such as event streams.
+	ctx, cancelRequests := context.WithCancel(context.Background())
+	baseContext := func(net.Listener) context.Context { return ctx }
+	var lns []net.Listener
+	addrs := []string{s.opts.Addr}
+	s.servers = []*http.Server{{Handler: s.Handler(), BaseContext: baseContext}}
+	if s.opts.GRPCAddr != "" {
+		addrs = append(addrs, s.opts.GRPCAddr)
+		s.servers = append(s.servers, &http.Server{Handler: logRequests(s.traceRequests(http.HandlerFunc(s.serveGRPC))), Protocols: grpcProtocols(), BaseContext: baseContext})
+	}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			cancelRequests()
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	for i, srv := range s.servers {
+		go func() {
+			if err := srv.Serve(lns[i]); err != http.ErrServerClosed {
+				s.errc <- err
+			}
+		}()
+	}
+	s.listener, s.cancelRequests = lns[0], cancelRequests
+	s.ready.Store(true)
+	slog.Info("Listening", "http", lns[0].Addr(), "grpc", s.opts.GRPCAddr, "node", s.clock.Node())
+	return nil
+}
+
+// Addr returns the address that the server's HTTP listener is listening on,
+// or nil if it hasn't been started.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Err returns a channel that receives the error if the server stops serving
+// (other than because of Shutdown).
+func (s *Server) Err() <-chan error { return s.errc }
+
+// AddPeers adds peers (in "host:port" format) to broadcast links to.
+func (s *Server) AddPeers(hosts ...string) { s.addPeerHosts(hosts) }
+
+// RemovePeers removes peers (in "host:port" format), so that links are no
+// longer broadcast to them. Links already queued for them are still sent.
+func (s *Server) RemovePeers(hosts ...string) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	for _, host := range hosts {
+		delete(s.peers, host)
+	}
+}
+
+// Peers returns the server's peers (in "host:port" format), sorted.
+func (s *Server) Peers() []string { return s.peerHosts() }
+
+// SetLimits changes Options.MaxFetches and Options.FetchTimeout (with the
+// same defaults) while the server runs. Fetches already running are not
+// affected.
+func (s *Server) SetLimits(maxFetches int, fetchTimeout time.Duration) {
+	if maxFetches <= 0 {
+		maxFetches = defaultMaxFetches
+	}
+	s.fetchLimit.setLimit(maxFetches)
+	s.fetchTimeout.Store(int64(fetchTimeout))
+	slog.Info("Set limits", "max_fetches", maxFetches, "fetch_timeout", fetchTimeout)
+}
+
+// isAdmin reports whether r carries Options.AdminToken as a bearer token
+// (or no token is needed).
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.opts.AdminToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) == 1
+}
+
+// requireAdmin returns a handler that serves requests with h if isAdmin
+// allows them. If methods are given, requests with other methods are
+// always allowed.
+func (s *Server) requireAdmin(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if (len(methods) == 0 || slices.Contains(methods, r.Method)) && !s.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gophurls"`)
+			http.Error(w, "missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) home(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	// Get the last event ID before listing the links, so that live.js
+	// replays any change made in between (which it may already show, which
+	// is harmless) rather than missing it.
+	lastEventID := s.events.lastID()
+	renderTemplate(w, "home", struct {
+		Links       []*link
+		Flash       string
+		CSRFToken   string
+		Bookmarklet template.URL
+		LastEventID string
+	}{
+		Links:       s.listLinks(),
+		Flash:       popFlash(w, r),
+		CSRFToken:   csrfToken(w, r),
+		Bookmarklet: bookmarklet(r.Host),
+		LastEventID: lastEventID,
+	})
+}
+
+// postLinks handles POST /links, which adds a link submitted by a user or
+// broadcasted by a peer, and GET /links, which lists links as JSON. Links
+// without titles are stored and broadcasted after their title is fetched.
+//
+// The link is either a JSON object or, when submitted from the form on the
+// homepage, form data (see postLinkForm).
+func (s *Server) postLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(s.listLinks())
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method must be GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if isForm(r) {
+		s.postLinkForm(w, r)
+		return
+	}
+	if !allowJSON(w, r) {
+		return
+	}
+	var l *link
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+		return
+	}
+	if l == nil {
+		http.Error(w, "no url", http.StatusBadRequest)
+		return
+	}
+	setOrigin(r, l)
+	if _, err := s.submitLink(l); err != nil {
+		http.Error(w, err.Error(), submitErrorStatus(err))
+	}
+}
+
+// submitErrorStatus returns the HTTP status code to respond with when
+// submitLink returns err.
+func submitErrorStatus(err error) int {
+	switch err {
+	case errLinkDeleted:
+		return http.StatusGone
+	case errShuttingDown:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// linkEdit is the JSON body of PATCH /links/{id}. Nil fields are left
+// unchanged.
+type linkEdit struct {
+	Title *string
+	Tags  *[]string
+}
+
+// serveLink handles DELETE and PATCH requests to /links/{id}, which delete
+// and edit a link, respectively. The change is broadcasted to peers.
+func (s *Server) serveLink(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/links/")
+	if r.Method != "DELETE" && r.Method != "PATCH" {
+		http.Error(w, "method must be DELETE or PATCH", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.draining.Load() {
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	l := s.getLink(id)
+	if l == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if l.deleted() {
+		http.Error(w, "link was deleted", http.StatusGone)
+		return
+	}
+
+	var e crdt.Entry[crdt.Link]
+	if r.Method == "DELETE" {
+		var ok bool
+		if e, ok = s.links.Remove(l.URL); !ok {
+			http.Error(w, "link was deleted", http.StatusGone)
+			return
+		}
+	} else {
+		var edit linkEdit
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			http.Error(w, fmt.Sprintf("bad JSON: %s", err), http.StatusBadRequest)
+			return
+		}
+		var v crdt.Link
+		if edit.Title != nil {
+			if *edit.Title == "" {
+				http.Error(w, "title must not be empty", http.StatusBadRequest)
+				return
+			}
+			v.Title = v.Title.Set(*edit.Title, s.clock.Now())
+		}
+		if edit.Tags != nil {
+			v.Tags = v.Tags.Set(*edit.Tags, s.clock.Now())
+		}
+		e = s.links.Update(l.URL, v)
+	}
+	s.publishChange(l.URL, l.entry(), e)
+	s.broadcast(s.linkFromEntry(l.URL, e))
+}
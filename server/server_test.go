@@ -1,23 +1,31 @@
-package main
+package server
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 )
 
-// h is an alias (to save typing) to the default router with the handler we
-// registered in server.go's init function.
-var h = http.DefaultServeMux
+// newTestServer returns a new server (which isn't listening; tests call its
+// Handler directly) with no links or peers. Its senders and streams to peers
+// are stopped when the test ends.
+func newTestServer(t *testing.T) *Server {
+	s := New(Options{})
+	t.Cleanup(s.stop)
+	return s
+}
 
 // TestGetHome tests that the homepage returns successfully and includes the
 // word "Links" somewhere.
 func TestGetHome(t *testing.T) {
+	s := newTestServer(t)
 	resp := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
-	h.ServeHTTP(resp, req)
+	s.Handler().ServeHTTP(resp, req)
 
 	testStatusCode(t, "homepage", resp.Code, http.StatusOK)
 	if body := resp.Body.String(); !strings.Contains(body, "Links") {
@@ -29,7 +37,7 @@ func TestGetHome(t *testing.T) {
 // succeeds, that the title is fetched, and that subsequently the
 // homepage contains the title of the newly added link.
 func TestAddLink_NoTitle(t *testing.T) {
-	peers = nil
+	s := newTestServer(t)
 
 	// Start a test server that returns a page with a <title> tag, so we can
 	// fetch locally.
@@ -43,7 +51,7 @@ func TestAddLink_NoTitle(t *testing.T) {
 	// Add the URL of the fake server.
 	resp := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"`+fakeServer.URL+`"}`))
-	h.ServeHTTP(resp, req)
+	s.Handler().ServeHTTP(resp, req)
 
 	testStatusCode(t, "after adding a link without a title", resp.Code, http.StatusOK)
 
@@ -53,7 +61,7 @@ func TestAddLink_NoTitle(t *testing.T) {
 	// Test that the link now appears in the list.
 	resp = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/", nil)
-	h.ServeHTTP(resp, req)
+	s.Handler().ServeHTTP(resp, req)
 	if body := resp.Body.String(); !strings.Contains(body, "Example") {
 		t.Errorf(`want "Example" to appear somewhere on homepage after adding a link, got %q`, body)
 	}
@@ -62,20 +70,20 @@ func TestAddLink_NoTitle(t *testing.T) {
 // TestAddLink_WithTitle tests that adding a link (with a title) succeeds, and
 // that subsequently the homepage contains the title of the newly added link.
 func TestAddLink_WithTitle(t *testing.T) {
-	peers = nil
+	s := newTestServer(t)
 
 	// Add a link with the title field set (which means we don't need to fetch
 	// the link to determine the title).
 	resp := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"http://example.com","Title":"Example"}`))
-	h.ServeHTTP(resp, req)
+	s.Handler().ServeHTTP(resp, req)
 
 	testStatusCode(t, "after adding a link with a title", resp.Code, http.StatusOK)
 
 	// Test that the link now appears in the list.
 	resp = httptest.NewRecorder()
 	req, _ = http.NewRequest("GET", "/", nil)
-	h.ServeHTTP(resp, req)
+	s.Handler().ServeHTTP(resp, req)
 	if body := resp.Body.String(); !strings.Contains(body, "Example") {
 		t.Errorf(`want "Example" to appear somewhere on homepage after adding a link, got %q`, body)
 	}
@@ -86,3 +94,50 @@ func testStatusCode(t *testing.T, label string, got, want int) {
 		t.Errorf("%s: got HTTP %d, want HTTP %d", label, got, want)
 	}
 }
+
+// TestServer_Embedded tests that two Servers in one process replicate links
+// to each other, and that a Server started with the same data file as one
+// that was shut down loads its links.
+func TestServer_Embedded(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "links.json")
+	a := New(Options{Addr: "127.0.0.1:0", DataFile: dataFile})
+	b := New(Options{Addr: "127.0.0.1:0"})
+	for _, s := range []*Server{a, b} {
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.AddPeers(b.Addr().String())
+	b.AddPeers(a.Addr().String())
+
+	resp, err := http.Post("http://"+a.Addr().String()+"/links", "application/json", strings.NewReader(`{"URL":"http://embedded.example.com","Title":"Embedded"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	testStatusCode(t, "after adding a link", resp.StatusCode, http.StatusOK)
+	deadline := time.Now().Add(time.Second)
+	for b.getLink(LinkID("http://embedded.example.com")) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("link was not replicated to the other server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, s := range []*Server{a, b} {
+		if err := s.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := New(Options{Addr: "127.0.0.1:0", DataFile: dataFile})
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown(ctx)
+	if l := c.getLink(LinkID("http://embedded.example.com")); l == nil || l.Title != "Embedded" {
+		t.Errorf("got link %+v after restarting, want it loaded from the data file", l)
+	}
+}
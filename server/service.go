@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+)
+
+// The functions in this file are the operations on links and peers shared
+// by the HTTP and gRPC APIs, so that the two behave the same.
+
+// errShuttingDown is returned by submitLink once the server has started
+// shutting down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// errLinkDeleted is returned by submitLink for links that were deleted.
+var errLinkDeleted = errors.New("link was deleted")
+
+// submitResult describes what submitLink did with a link.
+type submitResult string
+
+const (
+	submitAdded     submitResult = "added"     // added or updated
+	submitDuplicate submitResult = "duplicate" // we already had it
+	submitFetching  submitResult = "fetching"  // added once its title is fetched
+)
+
+// submitLink validates and adds l (after fetching its title, if needed),
+// and broadcasts it to peers. It returns an error if l is invalid or was
+// deleted.
+func (s *Server) submitLink(l *link) (submitResult, error) {
+	return s.submit(l, true)
+}
+
+// submit is submitLink, but only broadcasts l if share is true.
+func (s *Server) submit(l *link, share bool) (result submitResult, err error) {
+	defer func() { s.recordSubmission(l, result, err) }()
+	if s.draining.Load() {
+		return "", errShuttingDown
+	}
+	switch result, err = s.checkLink(l); {
+	case err != nil || result == submitDuplicate:
+		return result, err
+	case result == submitFetching:
+		go s.fetchAndAdd(l, share)
+		return result, nil
+	}
+	if !s.addLink(l, share) {
+		return submitDuplicate, nil
+	}
+	return submitAdded, nil
+}
+
+// checkLink validates l and decides what submitLink does with it: it
+// returns an error if l is invalid or was deleted, submitDuplicate if we
+// already have it, submitFetching if its title must be fetched first, and
+// otherwise submitAdded (whether merging it changes anything is only known
+// once it is merged).
+func (s *Server) checkLink(l *link) (submitResult, error) {
+	if err := validateURL(l.URL); err != nil {
+		return "", err
+	}
+
+	// A link without State that we already have is either a duplicate
+	// submission or a stale copy of a link that was since deleted (which
+	// must not be resurrected).
+	if e, known := s.links.Get(l.URL); known && l.State == nil {
+		if !e.Merge(l.entry()).Present() {
+			return "", errLinkDeleted
+		}
+		if l.Title == "" {
+			return submitDuplicate, nil
+		}
+	}
+
+	if l.Title == "" && l.State == nil {
+		return submitFetching, nil
+	}
+	return submitAdded, nil
+}
+
+// recordSubmission records the result of submitting l in the
+// link_submissions metric and the debug log.
+func (s *Server) recordSubmission(l *link, result submitResult, err error) {
+	label := string(result)
+	switch {
+	case err == errLinkDeleted:
+		label = "deleted"
+	case err == errShuttingDown:
+		label = "shutting_down"
+	case err != nil:
+		label = "invalid"
+	}
+	s.metrics.linkSubmissions.inc(label)
+	slog.Debug("Link submitted", "url", l.URL, "result", label, "request_id", l.requestID)
+}
+
+// validateURL returns an error if url is not a valid link URL.
+func validateURL(u string) error {
+	if u == "" {
+		return errors.New("no url")
+	}
+	if !isWebURL(u) {
+		return errors.New("bad url (must be an absolute http or https URL)")
+	}
+	return nil
+}
+
+// addLink merges l into our links. If that changed anything and share is
+// true, it broadcasts the result to peers. It reports whether anything
+// changed.
+func (s *Server) addLink(l *link, share bool) bool {
+	return s.addLinks([]*link{l}, share)[0]
+}
+
+// addLinks is like addLink, but merges all of ls at once (see mergeLinks).
+// It reports whether each changed anything.
+func (s *Server) addLinks(ls []*link, share bool) []bool {
+	merged, changed := s.mergeLinks(ls)
+	var shared []*link
+	for i, l := range ls {
+		merged[i].requestID, merged[i].trace = l.requestID, l.trace
+		if changed[i] && share {
+			shared = append(shared, merged[i])
+		}
+	}
+	if len(shared) > 0 {
+		s.broadcast(shared...)
+	}
+	return changed
+}
+
+// addPeerHosts adds the peers in hosts (in "host:port" format).
+func (s *Server) addPeerHosts(hosts []string) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	for _, host := range hosts {
+		s.peers[host] = struct{}{}
+	}
+}
+
+// peerHosts returns our peers (in "host:port" format), sorted.
+func (s *Server) peerHosts() []string {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	hosts := make([]string, 0, len(s.peers))
+	for host := range s.peers {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot is what is saved to the data file (see Options.DataFile). Peers
+// aren't saved: Options.Peers (from the config) are the initial peers, so
+// that a peer removed from the config stays removed after a restart.
+type snapshot struct {
+	Links []storedLink
+
+	// Fetches holds links whose titles were still being fetched, which are
+	// fetched again at startup.
+	Fetches []*link `json:",omitempty"`
+
+	// Broadcasts holds links (keyed by peer host:port) that were not yet
+	// sent to peers, which are sent at startup.
+	Broadcasts map[string][]*link `json:",omitempty"`
+}
+
+// storedLink is a link (including deleted links, which must stay deleted)
+// in a snapshot.
+type storedLink struct {
+	Link  *link
+	Added time.Time `json:",omitzero"`
+}
+
+// Shutdown gracefully shuts the server down: it stops accepting new
+// submissions and requests, waits (until ctx is done) for title fetches and
+// broadcasts to finish, and saves the store and any unfinished work to
+// Options.DataFile. Once it returns, the server no longer sends links to its
+// peers.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline).Round(time.Millisecond)
+	}
+	slog.Info("Shutting down", "timeout", timeout)
+	s.draining.Store(true)
+	s.ready.Store(false)
+	defer s.stop()
+
+	// End long-lived requests (event streams and peer streams), then wait for
+	// the others to finish.
+	if s.cancelRequests != nil {
+		s.cancelRequests()
+	}
+	for _, srv := range s.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+
+	// Fetches that finish are broadcasted, so wait for them first.
+	waitUntil(ctx, func() bool { return len(s.pendingFetches()) == 0 })
+	waitUntil(ctx, func() bool { return s.pendingBroadcasts() == 0 })
+
+	defer spans.flush()
+	snap := snapshot{Fetches: s.pendingFetches(), Broadcasts: s.takeBroadcasts()}
+	if s.opts.DataFile == "" {
+		if len(snap.Fetches) > 0 || len(snap.Broadcasts) > 0 {
+			slog.Warn("Unfinished work lost at shutdown (set a data file to save it)", "fetches", len(snap.Fetches), "broadcasts", countLinks(snap.Broadcasts))
+		}
+		return nil
+	}
+	for _, url := range s.links.Keys() {
+		e, _ := s.links.Get(url)
+		l := s.linkFromEntry(url, e)
+		snap.Links = append(snap.Links, storedLink{Link: l, Added: l.Added})
+	}
+	if err := saveSnapshot(s.opts.DataFile, &snap); err != nil {
+		return fmt.Errorf("saving links to %s: %s", s.opts.DataFile, err)
+	}
+	slog.Info("Saved links", "file", s.opts.DataFile, "links", len(snap.Links), "fetches", len(snap.Fetches), "broadcasts", countLinks(snap.Broadcasts))
+	return nil
+}
+
+// waitUntil polls done until it returns true or ctx is done.
+func waitUntil(ctx context.Context, done func() bool) {
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// pendingBroadcasts returns the number of links that are queued to be sent,
+// or not yet acknowledged by, peers.
+func (s *Server) pendingBroadcasts() int {
+	var n int64
+	s.sendersMu.Lock()
+	for _, ps := range s.senders {
+		n += ps.pending.Load()
+	}
+	s.sendersMu.Unlock()
+	s.streamsMu.Lock()
+	for _, ps := range s.streams {
+		n += ps.pending.Load()
+	}
+	s.streamsMu.Unlock()
+	return int(n)
+}
+
+// pendingFetches returns the links whose titles are still being fetched.
+func (s *Server) pendingFetches() []*link {
+	s.fetchingMu.Lock()
+	defer s.fetchingMu.Unlock()
+	var ls []*link
+	for _, l := range s.fetching {
+		ls = append(ls, l)
+	}
+	return ls
+}
+
+// takeBroadcasts removes and returns the links that haven't been sent to
+// (or acked by) each peer.
+func (s *Server) takeBroadcasts() map[string][]*link {
+	bs := make(map[string][]*link)
+	take := func(host string, q chan *link) {
+		for {
+			select {
+			case l := <-q:
+				bs[host] = append(bs[host], l)
+			default:
+				return
+			}
+		}
+	}
+	s.sendersMu.Lock()
+	for host, ps := range s.senders {
+		bs[host] = append(bs[host], ps.takeRetries()...)
+		take(host, ps.queue)
+	}
+	s.sendersMu.Unlock()
+	s.streamsMu.Lock()
+	for host, ps := range s.streams {
+		ps.mu.Lock()
+		for _, b := range ps.unacked {
+			bs[host] = append(bs[host], b.Links...)
+		}
+		ps.mu.Unlock()
+		take(host, ps.queue)
+	}
+	s.streamsMu.Unlock()
+	for host, ls := range bs {
+		if len(ls) == 0 {
+			delete(bs, host)
+		}
+	}
+	return bs
+}
+
+func countLinks(m map[string][]*link) int {
+	var n int
+	for _, ls := range m {
+		n += len(ls)
+	}
+	return n
+}
+
+// saveSnapshot writes snap to the file at path, replacing it atomically.
+func saveSnapshot(path string, snap *snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot adds the links in the file at path to the store, and resumes
+// the title fetches and broadcasts saved in it. (Saved broadcasts are sent
+// even to hosts that are no longer peers, since they were queued while they
+// were.) A missing file is not an
+// error (there's nothing to load on the first run).
+func (s *Server) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var snap snapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	for _, sl := range snap.Links {
+		if sl.Link == nil {
+			continue
+		}
+		sl.Link.Added = sl.Added
+		s.mergeLink(sl.Link)
+	}
+	for _, l := range snap.Fetches {
+		go s.fetchAndAdd(l, true)
+	}
+	for host, ls := range snap.Broadcasts {
+		for _, l := range ls {
+			if s.opts.Stream {
+				s.streamTo(host).send(l)
+			} else {
+				s.senderTo(host).send(l)
+			}
+		}
+	}
+	slog.Info("Loaded links", "file", path, "links", len(snap.Links), "fetches", len(snap.Fetches), "broadcasts", countLinks(snap.Broadcasts))
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// TestDraining tests that new submissions are rejected once the server
+// starts shutting down.
+func TestDraining(t *testing.T) {
+	s := newTestServer(t)
+	s.draining.Store(true)
+
+	resp := doRequest(s, "POST", "/links", `{"URL":"http://example.com/draining"}`)
+	testStatusCode(t, "adding a link while draining", resp.Code, http.StatusServiceUnavailable)
+	resp = doRequest(s, "POST", "/links/batch", `[{"URL":"http://example.com/draining"}]`)
+	testStatusCode(t, "adding a batch while draining", resp.Code, http.StatusServiceUnavailable)
+	resp = doRequest(s, "POST", "/import", `{"URL":"http://example.com/draining","Title":"Draining"}`)
+	testStatusCode(t, "importing links while draining", resp.Code, http.StatusServiceUnavailable)
+	if _, present := s.links.Get("http://example.com/draining"); present {
+		t.Error("link submitted while draining was added")
+	}
+}
+
+// TestSnapshot tests that links (including deleted links) and undelivered
+// broadcasts saved at shutdown are restored at startup.
+func TestSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	received, closeFakePeer := startFakePeer(t, s)
+	defer closeFakePeer()
+	peer := s.Peers()[0]
+
+	path := filepath.Join(t.TempDir(), "links.json")
+	deleted := crdt.Entry[crdt.Link]{Adds: []crdt.Timestamp{{}}, Removes: []crdt.Timestamp{{}}}
+	snap := snapshot{
+		Links: []storedLink{
+			{Link: &link{URL: "http://example.com/saved", Title: "Saved"}},
+			{Link: &link{URL: "http://example.com/saved-deleted", State: &deleted}},
+		},
+		Broadcasts: map[string][]*link{peer: {{URL: "http://example.com/saved", Title: "Saved"}}},
+	}
+	if err := saveSnapshot(path, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, present := s.links.Get("http://example.com/saved"); !present || !e.Present() || e.Value.Title.Value != "Saved" {
+		t.Errorf("got saved link %+v (present: %v), want it present with its title", e, present)
+	}
+	if e, present := s.links.Get("http://example.com/saved-deleted"); !present || e.Present() {
+		t.Errorf("got saved deleted link %+v (present: %v), want it deleted", e, present)
+	}
+	if l := waitForLink(t, received); l.URL != "http://example.com/saved" {
+		t.Errorf("got broadcasted link %q, want the saved broadcast", l.URL)
+	}
+
+	// A missing file isn't an error.
+	if err := s.loadSnapshot(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("loading a missing file: %s", err)
+	}
+}
+
+// TestSnapshot_Peers tests that peers aren't restored from the data file,
+// so that the configured peers are the initial peers.
+func TestSnapshot_Peers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.json")
+	if err := os.WriteFile(path, []byte(`{"Links":[],"Peers":["removed.example.com:7000"]}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{Peers: []string{"configured.example.com:7000"}})
+	t.Cleanup(s.stop)
+	if err := s.loadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if peers := s.Peers(); len(peers) != 1 || peers[0] != "configured.example.com:7000" {
+		t.Errorf("got peers %v, want only the configured peer", peers)
+	}
+}
@@ -0,0 +1,62 @@
+// Keeps the list of links on the homepage up to date by subscribing to the
+// server's /events stream.
+(function() {
+	var list = document.querySelector("ol.links");
+	if (!list || !window.EventSource) {
+		return;
+	}
+
+	function isWebURL(u) {
+		return /^https?:\/\//i.test(u);
+	}
+
+	function render(ev) {
+		var li = document.createElement("li");
+		li.setAttribute("data-id", ev.LinkID);
+		var a = document.createElement("a");
+		a.href = isWebURL(ev.Link.URL) ? ev.Link.URL : "#";
+		a.rel = "noopener noreferrer";
+		a.textContent = ev.Link.Title;
+		li.appendChild(a);
+		(ev.Link.Tags || []).forEach(function(tag) {
+			var span = document.createElement("span");
+			span.className = "tag";
+			span.textContent = tag;
+			li.appendChild(document.createTextNode(" "));
+			li.appendChild(span);
+		});
+		return li;
+	}
+
+	function find(id) {
+		return list.querySelector('li[data-id="' + id + '"]');
+	}
+
+	// Resume from the last event that the page was rendered with, so that no
+	// changes are missed in between.
+	var source = new EventSource("/events?lastEventId=" + encodeURIComponent(list.getAttribute("data-last-event-id")));
+	source.addEventListener("link-added", function(e) {
+		var ev = JSON.parse(e.data);
+		if (!find(ev.LinkID)) {
+			list.appendChild(render(ev));
+		}
+	});
+	source.addEventListener("link-updated", function(e) {
+		var ev = JSON.parse(e.data), old = find(ev.LinkID);
+		if (old) {
+			list.replaceChild(render(ev), old);
+		} else {
+			list.appendChild(render(ev));
+		}
+	});
+	source.addEventListener("link-deleted", function(e) {
+		var old = find(JSON.parse(e.data).LinkID);
+		if (old) {
+			list.removeChild(old);
+		}
+	});
+	source.addEventListener("reset", function() {
+		// We missed events that the server no longer has, so start over.
+		location.reload();
+	});
+})();
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// serveHealthz handles GET /healthz, which reports that the server is alive
+// (even if it's not ready yet).
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// serveReadyz handles GET /readyz, which responds with 503 Service
+// Unavailable until the server is ready.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("content-type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// peerState is what we know about sending links to a peer.
+type peerState struct {
+	Host        string
+	Mode        string // how links are sent: "POST", "stream" or "stream (falling back to POST)"
+	QueueDepth  int    // links waiting to be sent
+	Successes   int
+	Failures    int
+	LastSuccess time.Time `json:",omitzero"`
+	LastFailure time.Time `json:",omitzero"`
+	LastError   string    `json:",omitempty"`
+}
+
+// recordPeerResult records the result of sending links to the peer at host.
+func (s *Server) recordPeerResult(host string, err error) {
+	s.peerResultsMu.Lock()
+	defer s.peerResultsMu.Unlock()
+	ps, present := s.peerResults[host]
+	if !present {
+		ps = &peerState{Host: host}
+		s.peerResults[host] = ps
+	}
+	if err != nil {
+		ps.Failures++
+		ps.LastFailure, ps.LastError = time.Now(), err.Error()
+	} else {
+		ps.Successes++
+		ps.LastSuccess = time.Now()
+	}
+}
+
+// peerStates returns the state of each of our peers, sorted by host.
+func (s *Server) peerStates() []peerState {
+	depths := s.peerQueueDepths()
+	var states []peerState
+	for _, host := range s.peerHosts() {
+		ps := peerState{Host: host}
+		s.peerResultsMu.Lock()
+		if r, present := s.peerResults[host]; present {
+			ps = *r
+		}
+		s.peerResultsMu.Unlock()
+		ps.Mode = "POST"
+		if s.opts.Stream {
+			ps.Mode = "stream"
+			s.streamsMu.Lock()
+			stream := s.streams[host]
+			s.streamsMu.Unlock()
+			if stream != nil && stream.legacy.Load() {
+				ps.Mode = "stream (falling back to POST)"
+			}
+		}
+		ps.QueueDepth = depths[host]
+		states = append(states, ps)
+	}
+	return states
+}
+
+// linkCounts returns the number of links in the store that are present and
+// deleted.
+func (s *Server) linkCounts() (present, deleted int) {
+	for _, url := range s.links.Keys() {
+		if e, _ := s.links.Get(url); e.Present() {
+			present++
+		} else {
+			deleted++
+		}
+	}
+	return present, deleted
+}
+
+// fetchQueue returns the number of title fetches that are running and
+// waiting to run.
+func (s *Server) fetchQueue() (inFlight, queued int) {
+	s.fetchingMu.Lock()
+	defer s.fetchingMu.Unlock()
+	inFlight = s.fetchLimit.inFlight()
+	return inFlight, max(0, len(s.fetching)-inFlight)
+}
+
+// peerQueueDepths returns the number of links waiting to be sent to each
+// peer.
+func (s *Server) peerQueueDepths() map[string]int {
+	depths := make(map[string]int)
+	s.sendersMu.Lock()
+	for host, ps := range s.senders {
+		depths[host] += len(ps.queue)
+	}
+	s.sendersMu.Unlock()
+	s.streamsMu.Lock()
+	for host, ps := range s.streams {
+		depths[host] += len(ps.queue)
+	}
+	s.streamsMu.Unlock()
+	return depths
+}
+
+// buildInfo describes the server binary.
+type buildInfo struct {
+	GoVersion string
+	Path      string `json:",omitempty"`
+	Version   string `json:",omitempty"`
+	Revision  string `json:",omitempty"`
+	Time      string `json:",omitempty"`
+	Modified  bool   `json:",omitempty"`
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Path, b.Version = bi.Main.Path, bi.Main.Version
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// adminStatus is the summary shown by /admin/status.
+type adminStatus struct {
+	Node         string
+	Ready        bool
+	Started      time.Time
+	Uptime       string
+	Links        int
+	DeletedLinks int
+	Fetches      struct{ InFlight, Queued int }
+	Peers        []peerState
+	Build        buildInfo
+}
+
+// serveAdminStatus handles GET /admin/status, which summarizes the state of
+// the server as an HTML page, or as JSON if the client accepts JSON (or the
+// "format" query parameter is "json").
+func (s *Server) serveAdminStatus(w http.ResponseWriter, r *http.Request) {
+	st := adminStatus{
+		Node:    s.clock.Node(),
+		Ready:   s.ready.Load(),
+		Started: s.startTime,
+		Uptime:  time.Since(s.startTime).Round(time.Second).String(),
+		Peers:   s.peerStates(),
+		Build:   readBuildInfo(),
+	}
+	st.Links, st.DeletedLinks = s.linkCounts()
+	st.Fetches.InFlight, st.Fetches.Queued = s.fetchQueue()
+
+	if r.FormValue("format") == "json" || strings.Contains(r.Header.Get("accept"), "application/json") {
+		w.Header().Set("content-type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(st)
+		return
+	}
+	renderTemplate(w, "status", st)
+}
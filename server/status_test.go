@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestReadyz tests that /readyz fails until the server is ready, while
+// /healthz always succeeds.
+func TestReadyz(t *testing.T) {
+	s := newTestServer(t) // not ready, since it hasn't been started
+	testStatusCode(t, "healthz before ready", doRequest(s, "GET", "/healthz", "").Code, http.StatusOK)
+	testStatusCode(t, "readyz before ready", doRequest(s, "GET", "/readyz", "").Code, http.StatusServiceUnavailable)
+	s.ready.Store(true)
+	testStatusCode(t, "readyz when ready", doRequest(s, "GET", "/readyz", "").Code, http.StatusOK)
+}
+
+// TestAdminStatus tests that /admin/status summarizes links and peers, as
+// JSON and as HTML.
+func TestAdminStatus(t *testing.T) {
+	s := newTestServer(t)
+	s.AddPeers("status.example.com:1")
+	s.recordPeerResult("status.example.com:1", nil)
+
+	resp := doRequest(s, "GET", "/admin/status?format=json", "")
+	testStatusCode(t, "admin status (JSON)", resp.Code, http.StatusOK)
+	var st adminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Node != s.clock.Node() || st.Build.GoVersion == "" {
+		t.Errorf("got status %+v, want node and build info", st)
+	}
+	if len(st.Peers) != 1 || st.Peers[0].Host != "status.example.com:1" || st.Peers[0].Successes != 1 {
+		t.Errorf("got peers %+v, want status.example.com:1 with 1 success", st.Peers)
+	}
+
+	resp = doRequest(s, "GET", "/admin/status", "")
+	testStatusCode(t, "admin status (HTML)", resp.Code, http.StatusOK)
+	if body := resp.Body.String(); !strings.Contains(body, "status.example.com:1") {
+		t.Errorf("want peer in status page, got %q", body)
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The peer stream protocol: a peer opens a long-lived POST /peers/stream
+// request and writes a batch (a JSON object) of links to the request body
+// whenever it has links to send. The receiver adds the links and writes an
+// ack (also a JSON object) to the response body once each batch is
+// processed. Batches that aren't acked when the stream breaks are resent on
+// the next stream.
+
+// streamBatch is a batch of links sent on a peer stream.
+type streamBatch struct {
+	Seq   int64
+	Links []*link
+
+	// RequestIDs holds the request ID of each link (see requestIDHeader),
+	// if any have one.
+	RequestIDs []string `json:",omitempty"`
+
+	// TraceParents holds the trace context of each link, as traceparent
+	// header values, if any have one.
+	TraceParents []string `json:",omitempty"`
+
+	sent time.Time // when the batch was first sent
+	span *span     // ends when the batch is acked
+}
+
+// streamAck acknowledges that all batches up to Seq were processed.
+type streamAck struct {
+	Seq int64
+}
+
+const (
+	// maxBatchSize is the maximum number of links in one batch.
+	maxBatchSize = 100
+
+	// batchDelay is how long to wait for more links before sending a batch.
+	batchDelay = 5 * time.Millisecond
+
+	// maxStreamBackoff is the maximum time to wait before reconnecting a
+	// broken stream.
+	maxStreamBackoff = 5 * time.Second
+
+	// legacyRecheckInterval is how often to check whether a peer that
+	// didn't support streams has been upgraded.
+	legacyRecheckInterval = time.Minute
+)
+
+// servePeerStream handles POST /peers/stream, which receives a stream of
+// link batches from a peer.
+func (s *Server) servePeerStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	rc := http.NewResponseController(w)
+	// Allow writing acks while still reading batches (HTTP/1.1 only; HTTP/2
+	// is always full duplex).
+	rc.EnableFullDuplex()
+	w.Header().Set("content-type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	// Stop reading when the server shuts down. The peer resends batches
+	// that weren't acked.
+	stop := context.AfterFunc(r.Context(), func() { rc.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	dec := json.NewDecoder(r.Body)
+	enc := json.NewEncoder(w)
+	for {
+		var b streamBatch
+		if err := dec.Decode(&b); err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				slog.Error("Error reading peer stream", "remote", r.RemoteAddr, "err", err)
+			}
+			return
+		}
+		for i, l := range b.Links {
+			if l == nil {
+				continue
+			}
+			if len(b.RequestIDs) == len(b.Links) {
+				l.requestID = b.RequestIDs[i]
+			}
+			if len(b.TraceParents) == len(b.Links) {
+				l.trace, _ = parseTraceparent(b.TraceParents[i])
+			}
+			_, err := s.submitLink(l)
+			if err == errShuttingDown {
+				return // without acking, so the peer resends the batch
+			}
+			if err != nil && err != errLinkDeleted {
+				slog.Error("Error adding link from peer stream", "remote", r.RemoteAddr, "url", l.URL, "err", err, "request_id", l.requestID)
+			}
+		}
+		if err := enc.Encode(streamAck{Seq: b.Seq}); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// errStreamUnsupported is returned when a peer doesn't support streams.
+var errStreamUnsupported = errors.New("peer does not support streams")
+
+// isStreamResponse reports whether resp is a peer stream's NDJSON response.
+func isStreamResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("content-type"))
+	return mediaType == "application/x-ndjson"
+}
+
+// peerStream sends links to one peer over a stream, reconnecting as needed.
+type peerStream struct {
+	srv     *Server
+	host    string
+	queue   chan *link
+	legacy  atomic.Bool  // whether links are being sent with POST /links
+	pending atomic.Int64 // links queued or sent but not yet acked
+
+	mu      sync.Mutex
+	seq     int64
+	unacked []streamBatch
+}
+
+// streamTo returns the stream to the peer at host, starting it if needed.
+func (s *Server) streamTo(host string) *peerStream {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	ps, present := s.streams[host]
+	if !present {
+		ps = &peerStream{srv: s, host: host, queue: make(chan *link, 1024)}
+		s.streams[host] = ps
+		go ps.run()
+	}
+	return ps
+}
+
+// run sends queued links to the peer until the server shuts down.
+func (s *peerStream) run() {
+	stopped := s.srv.stopped.Done()
+	backoff := 100 * time.Millisecond
+	clock := s.srv.opts.Clock
+	for {
+		t0 := clock.Now()
+		err := s.stream()
+		select {
+		case <-stopped:
+			return
+		default:
+		}
+		if err == errStreamUnsupported {
+			slog.Info("Peer does not support streams; sending links with POST /links", "peer", s.host)
+			s.sendLegacy(clock.Now().Add(legacyRecheckInterval))
+			continue
+		}
+		if clock.Now().Sub(t0) > maxStreamBackoff {
+			backoff = 100 * time.Millisecond // it was working for a while
+		}
+		s.srv.metrics.broadcasts.inc(s.host, "failure")
+		slog.Warn("Stream to peer broke", "peer", s.host, "reconnect_in", backoff, "err", err)
+		select {
+		case <-clock.After(backoff):
+		case <-stopped:
+			return
+		}
+		if backoff *= 2; backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// sendLegacy hands queued links (and any unacked batches) to the peer's
+// peerSender, which sends them with POST /links (retrying those that fail),
+// until the given time (or until the server shuts down).
+func (s *peerStream) sendLegacy(until time.Time) {
+	s.legacy.Store(true)
+	defer s.legacy.Store(false)
+	sender := s.srv.senderTo(s.host)
+	s.mu.Lock()
+	var ls []*link
+	for _, b := range s.unacked {
+		ls = append(ls, b.Links...)
+		b.span.finish(errStreamUnsupported)
+	}
+	s.unacked = nil
+	s.mu.Unlock()
+	for _, l := range ls {
+		sender.send(l)
+		s.pending.Add(-1)
+	}
+
+	clock := s.srv.opts.Clock
+	expired := clock.After(until.Sub(clock.Now()))
+	for {
+		select {
+		case l := <-s.queue:
+			sender.send(l)
+			s.pending.Add(-1)
+		case <-expired:
+			return
+		case <-s.srv.stopped.Done():
+			return
+		}
+	}
+}
+
+// stream opens a stream to the peer and sends batches of links on it until
+// it breaks.
+func (s *peerStream) stream() error {
+	streamURL := fmt.Sprintf("http://%s/peers/stream", s.host)
+
+	// Check that the peer supports streams with an empty stream first. A
+	// peer that doesn't would otherwise wait for the end of the stream's
+	// body (which never comes) before responding with an error. Peers that
+	// respond with 404 or 405, or with anything but an NDJSON stream (as one
+	// with a catch-all handler, such as one written following the workshop,
+	// does), don't support streams.
+	ctx := s.srv.stopped
+	req, err := http.NewRequestWithContext(ctx, "POST", streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/x-ndjson")
+	resp, err := s.srv.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if !isStreamResponse(resp) {
+			return errStreamUnsupported
+		}
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errStreamUnsupported
+	default:
+		return statusError(resp.StatusCode)
+	}
+
+	pr, pw := io.Pipe()
+	req, err = http.NewRequestWithContext(ctx, "POST", streamURL, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/x-ndjson")
+
+	// Write batches concurrently with reading acks. Before returning (and
+	// possibly reconnecting), stop the writer, so that it doesn't take links
+	// from the queue that the next stream's writer should send.
+	done, writerDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		s.writeBatches(pw, done)
+		close(writerDone)
+	}()
+	defer func() {
+		close(done)
+		pr.Close()
+		<-writerDone
+	}()
+
+	resp, err = s.srv.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode)
+	}
+	if !isStreamResponse(resp) {
+		return errStreamUnsupported
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ack streamAck
+		if err := dec.Decode(&ack); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		for len(s.unacked) > 0 && s.unacked[0].Seq <= ack.Seq {
+			s.srv.metrics.broadcasts.inc(s.host, "success")
+			s.srv.metrics.broadcastDuration.observeSince(s.unacked[0].sent, s.host)
+			s.unacked[0].span.finish(nil)
+			s.pending.Add(-int64(len(s.unacked[0].Links)))
+			s.unacked = s.unacked[1:]
+		}
+		s.mu.Unlock()
+	}
+}
+
+// writeBatches resends unacked batches and then writes new batches of
+// queued links to w, until done is closed or writing fails.
+func (s *peerStream) writeBatches(w *io.PipeWriter, done <-chan struct{}) {
+	enc := json.NewEncoder(w)
+	s.mu.Lock()
+	resend := append([]streamBatch(nil), s.unacked...)
+	s.mu.Unlock()
+	for _, b := range resend {
+		if err := enc.Encode(b); err != nil {
+			return
+		}
+	}
+
+	for {
+		var ls []*link
+		select {
+		case l := <-s.queue:
+			ls = append(ls, l)
+		case <-done:
+			w.Close()
+			return
+		}
+		// Wait briefly for more links to fill the batch.
+		delay := s.srv.opts.Clock.After(batchDelay)
+	fill:
+		for len(ls) < maxBatchSize {
+			select {
+			case l := <-s.queue:
+				ls = append(ls, l)
+			case <-delay:
+				break fill
+			}
+		}
+
+		s.mu.Lock()
+		s.seq++
+		b := streamBatch{Seq: s.seq, Links: ls, RequestIDs: linkRequestIDs(ls), TraceParents: linkTraceParents(ls), sent: time.Now()}
+		b.span = s.srv.startSpan(ls[0].trace, "broadcast batch", spanClient)
+		b.span.setAttr("server.address", s.host)
+		b.span.setAttr("gophurls.links", len(ls))
+		for _, l := range ls[1:] {
+			b.span.addLink(l.trace)
+		}
+		s.unacked = append(s.unacked, b)
+		s.mu.Unlock()
+		if err := enc.Encode(b); err != nil {
+			return // the batch stays unacked and is resent on the next stream
+		}
+	}
+}
+
+// send queues l to be sent on the stream. If the queue is full (because the
+// peer is unreachable), l is dropped.
+func (s *peerStream) send(l *link) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- l:
+	default:
+		s.pending.Add(-1)
+		slog.Warn("Stream queue to peer is full; dropping link", "peer", s.host, "url", l.URL, "request_id", l.requestID)
+	}
+}
+
+// linkTraceParents returns the trace context of each link in ls as a
+// traceparent header value, or nil if none have one.
+func linkTraceParents(ls []*link) []string {
+	tps := make([]string, len(ls))
+	var any bool
+	for i, l := range ls {
+		if l.trace.valid() {
+			tps[i], any = l.trace.traceparent(), true
+		}
+	}
+	if !any {
+		return nil
+	}
+	return tps
+}
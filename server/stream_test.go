@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestPeerStream_Receive tests that links sent on a peer stream are added
+// and acked.
+func TestPeerStream_Receive(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go json.NewEncoder(pw).Encode(streamBatch{Seq: 7, Links: []*link{{URL: "http://stream.example.com/in", Title: "Streamed in"}}})
+	resp, err := http.Post(server.URL+"/peers/stream", "application/x-ndjson", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	testStatusCode(t, "peer stream", resp.StatusCode, http.StatusOK)
+
+	var ack streamAck
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Seq != 7 {
+		t.Errorf("got ack %d, want 7", ack.Seq)
+	}
+	if l := s.getLink(LinkID("http://stream.example.com/in")); l == nil || l.Title != "Streamed in" {
+		t.Errorf("got link %+v, want link received on stream", l)
+	}
+}
+
+// TestPeerStream_Send tests that with Options.Stream, broadcasted links are
+// sent in batches on a stream to each peer.
+func TestPeerStream_Send(t *testing.T) {
+	s := New(Options{Stream: true})
+	defer s.stop()
+
+	received := make(chan *link, 10)
+	fakeMux := http.NewServeMux()
+	fakeMux.HandleFunc("/peers/stream", func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).EnableFullDuplex()
+		w.Header().Set("content-type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		dec, enc := json.NewDecoder(r.Body), json.NewEncoder(w)
+		for {
+			var b streamBatch
+			if err := dec.Decode(&b); err != nil {
+				return
+			}
+			for _, l := range b.Links {
+				received <- l
+			}
+			enc.Encode(streamAck{Seq: b.Seq})
+			w.(http.Flusher).Flush()
+		}
+	})
+	fakeServer := httptest.NewServer(fakeMux)
+	defer func() {
+		// Close the stream, which would otherwise keep Close waiting.
+		fakeServer.CloseClientConnections()
+		fakeServer.Close()
+	}()
+	fakeServerURL, _ := url.Parse(fakeServer.URL)
+	s.AddPeers(fakeServerURL.Host)
+
+	doRequest(s, "POST", "/links", `{"URL":"http://stream.example.com/out1","Title":"Out 1"}`)
+	doRequest(s, "POST", "/links", `{"URL":"http://stream.example.com/out2","Title":"Out 2"}`)
+	for _, want := range []string{"Out 1", "Out 2"} {
+		if l := waitForLink(t, received); l.Title != want {
+			t.Errorf("got link %+v on stream, want title %q", l, want)
+		}
+	}
+
+	// All batches are eventually acked.
+	ps := s.streamTo(fakeServerURL.Host)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		ps.mu.Lock()
+		n := len(ps.unacked)
+		ps.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d batches still unacked", n)
+		}
+	}
+}
+
+// TestPeerStream_Fallback tests that with Options.Stream, links are still
+// sent with POST /links to peers that don't support streams.
+func TestPeerStream_Fallback(t *testing.T) {
+	s := New(Options{Stream: true})
+	defer s.stop()
+
+	received, closeFakePeer := startFakePeer(t, s)
+	defer closeFakePeer()
+
+	doRequest(s, "POST", "/links", `{"URL":"http://stream.example.com/legacy","Title":"Legacy"}`)
+	if l := waitForLink(t, received); l.Title != "Legacy" {
+		t.Errorf("got link %+v, want title Legacy", l)
+	}
+}
+
+// TestPeerStream_FallbackCatchAll tests that with Options.Stream, links are
+// sent with POST /links to a peer that responds to POST /peers/stream with
+// 200 but not with a stream (as one with a catch-all handler does).
+func TestPeerStream_FallbackCatchAll(t *testing.T) {
+	s := New(Options{Stream: true})
+	defer s.stop()
+
+	received := make(chan *link, 10)
+	fakePeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/links" {
+			var l *link
+			json.NewDecoder(r.Body).Decode(&l)
+			received <- l
+		}
+		w.Header().Set("content-type", "text/html")
+		io.WriteString(w, "<html>Links</html>")
+	}))
+	defer fakePeer.Close()
+	fakePeerURL, _ := url.Parse(fakePeer.URL)
+	s.AddPeers(fakePeerURL.Host)
+
+	doRequest(s, "POST", "/links", `{"URL":"http://stream.example.com/catch-all","Title":"Catch-all"}`)
+	if l := waitForLink(t, received); l.Title != "Catch-all" {
+		t.Errorf("got link %+v, want title Catch-all", l)
+	}
+}
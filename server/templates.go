@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// assets holds the HTML templates and the static files served under
+// /static/.
+//
+//go:embed templates static
+var assets embed.FS
+
+var templateFuncs = template.FuncMap{"href": href, "linkID": LinkID}
+
+// templates holds the page templates, each of which is rendered inside
+// templates/layout.html.
+var templates = map[string]*template.Template{
+	"home":   parseTemplate("home.html"),
+	"share":  parseTemplate("share.html"),
+	"status": parseTemplate("status.html"),
+}
+
+func parseTemplate(name string) *template.Template {
+	return template.Must(template.New("layout.html").Funcs(templateFuncs).ParseFS(assets, "templates/layout.html", "templates/"+name))
+}
+
+// renderTemplate renders the named page template with data. Rendering
+// happens into a buffer first so that a template error results in an error
+// page, not a half-written page.
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := templates[name].Execute(&buf, data); err != nil {
+		slog.Error("Error rendering template", "template", name, "err", err)
+		http.Error(w, "error rendering page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// href returns u if it is an http or https URL, and "#" otherwise. Links are
+// submitted by anyone and broadcasted by peers we don't control, so a
+// javascript: (or other scheme) URL must never end up in an href.
+func href(u string) string {
+	if isWebURL(u) {
+		return u
+	}
+	return "#"
+}
+
+// isWebURL reports whether u is an absolute http or https URL.
+func isWebURL(u string) bool {
+	pu, err := url.Parse(u)
+	return err == nil && (pu.Scheme == "http" || pu.Scheme == "https") && pu.Host != ""
+}
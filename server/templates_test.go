@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/gophurls/crdt"
+)
+
+// TestHome_XSS tests that script payloads in links broadcasted by peers are
+// escaped on the homepage.
+func TestHome_XSS(t *testing.T) {
+	s := newTestServer(t)
+
+	// A link from a peer with script in its title and tags.
+	resp := doRequest(s, "POST", "/links", `{"URL":"http://xss.example.com/","Title":"<script>alert('title')</script>","Tags":["<img src=x onerror=alert(1)>"]}`)
+	testStatusCode(t, "after adding a link with script in its title", resp.Code, http.StatusOK)
+
+	// An edit from a peer with script in its title.
+	e := (&link{URL: "http://xss.example.com/edited", Title: "ok"}).entry()
+	e.Value.Title = e.Value.Title.Set(`"><script>alert('edit')</script>`, crdt.Timestamp{Wall: 1, Node: "peer"})
+	body, _ := json.Marshal(&link{URL: "http://xss.example.com/edited", State: &e})
+	resp = doRequest(s, "POST", "/links", string(body))
+	testStatusCode(t, "after adding an edited link with script in its title", resp.Code, http.StatusOK)
+
+	home := doRequest(s, "GET", "/", "").Body.String()
+	for _, bad := range []string{"<script>", "<img"} {
+		if strings.Contains(home, bad) {
+			t.Errorf("want %q to be escaped on homepage, got %q", bad, home)
+		}
+	}
+	if !strings.Contains(home, "&lt;script&gt;alert(&#39;title&#39;)&lt;/script&gt;") {
+		t.Errorf("want escaped title on homepage, got %q", home)
+	}
+}
+
+// TestAddLink_BadScheme tests that links with URLs that aren't http or https
+// (such as javascript: URLs) are rejected.
+func TestAddLink_BadScheme(t *testing.T) {
+	s := newTestServer(t)
+	for _, u := range []string{"javascript:alert(1)", "JavaScript:alert(1)", "data:text/html,<script>alert(1)</script>", "/relative"} {
+		resp := doRequest(s, "POST", "/links", `{"URL":"`+u+`","Title":"Click me"}`)
+		testStatusCode(t, "after adding link "+u, resp.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHref tests that href only allows http and https URLs.
+func TestHref(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com":      "http://example.com",
+		"https://example.com/a?b": "https://example.com/a?b",
+		"javascript:alert(1)":     "#",
+		" javascript:alert(1)":    "#",
+		"vbscript:msgbox(1)":      "#",
+		"//example.com":           "#",
+	}
+	for u, want := range tests {
+		if got := href(u); got != want {
+			t.Errorf("href(%q): got %q, want %q", u, got, want)
+		}
+	}
+}
+
+// TestStatic tests that static assets are served.
+func TestStatic(t *testing.T) {
+	s := newTestServer(t)
+	resp := doRequest(s, "GET", "/static/style.css", "")
+	testStatusCode(t, "stylesheet", resp.Code, http.StatusOK)
+}
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracing follows OpenTelemetry conventions, so that spans from all servers
+// (and anything else that takes part in a trace) can be viewed together:
+// trace context is propagated in W3C traceparent headers (see
+// https://www.w3.org/TR/trace-context/), and spans are exported with
+// OTLP/HTTP's JSON encoding (see
+// https://opentelemetry.io/docs/specs/otlp/).
+
+// spanContext identifies a span in a trace.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+func (sc spanContext) valid() bool { return sc.traceID != [16]byte{} && sc.spanID != [8]byte{} }
+
+// traceparent returns sc as a traceparent header value.
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.traceID, sc.spanID, flags)
+}
+
+// parseTraceparent parses a traceparent header value.
+func parseTraceparent(v string) (sc spanContext, ok bool) {
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' || v[:2] == "ff" {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(v[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(v[36:52])); err != nil {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(v[53:55], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags&1 == 1
+	return sc, sc.valid()
+}
+
+// Span kinds.
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// span is a timed operation in a trace. A nil *span (returned when tracing
+// is disabled) is valid and does nothing.
+type span struct {
+	sc     spanContext
+	parent spanContext
+	node   string // the node ID of the server that recorded the span
+	name   string
+	kind   int
+	start  time.Time
+	links  []spanContext
+
+	mu    sync.Mutex
+	attrs map[string]any
+	err   string
+	ended bool
+	end   time.Time
+}
+
+// startSpan starts a span that is a child of parent, or the root of a new
+// trace if parent is not valid. It returns nil if tracing is disabled or
+// parent was not sampled.
+func (s *Server) startSpan(parent spanContext, name string, kind int) *span {
+	if spans == nil || (parent.valid() && !parent.sampled) {
+		return nil
+	}
+	sp := &span{parent: parent, node: s.clock.Node(), name: name, kind: kind, start: time.Now(), attrs: make(map[string]any)}
+	sp.sc.sampled = true
+	if parent.valid() {
+		sp.sc.traceID = parent.traceID
+	} else {
+		rand.Read(sp.sc.traceID[:])
+	}
+	rand.Read(sp.sc.spanID[:])
+	return sp
+}
+
+// context returns the span's context, to propagate to child spans. For a
+// nil span, it returns parent unchanged, so that traces started elsewhere
+// still pass through servers that don't record spans.
+func (s *span) context(parent spanContext) spanContext {
+	if s == nil {
+		return parent
+	}
+	return s.sc
+}
+
+// setAttr sets an attribute of the span.
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// addLink links the span to another span that caused it (such as the
+// spans of links in a batch other than the first).
+func (s *span) addLink(sc spanContext) {
+	if s == nil || !sc.valid() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links = append(s.links, sc)
+}
+
+// finish ends the span (as failed, if err is not nil) and queues it for
+// export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+	spans.add(s)
+}
+
+// traceKey is the context key of the current span's context.
+type traceKey struct{}
+
+// withSpanContext returns a copy of ctx that carries sc.
+func withSpanContext(ctx context.Context, sc spanContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, sc)
+}
+
+// spanContextFrom returns the span context carried by ctx.
+func spanContextFrom(ctx context.Context) spanContext {
+	sc, _ := ctx.Value(traceKey{}).(spanContext)
+	return sc
+}
+
+// injectTrace sets the traceparent header of an outgoing request to sc.
+func injectTrace(h http.Header, sc spanContext) {
+	if sc.valid() {
+		h.Set("traceparent", sc.traceparent())
+	}
+}
+
+// traceRequests wraps h to record a server span for each request, which is
+// a child of the span in the request's traceparent header (if any), and to
+// carry the span's context in the request context.
+//
+// If h is a ServeMux, spans are named by the method and the pattern that
+// matches (such as "GET /links/"), not the path, so that there are few
+// distinct names. (The pattern is looked up rather than read from
+// r.Pattern, which the mux doesn't set with GODEBUG=httpmuxgo121=1.)
+// Otherwise they're named by the path, which for gRPC names the method.
+func (s *Server) traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := parseTraceparent(r.Header.Get("traceparent"))
+		name, route := r.Method+" "+r.URL.Path, ""
+		if mux, ok := h.(*http.ServeMux); ok {
+			name = r.Method
+			if _, route = mux.Handler(r); route != "" {
+				name += " " + route
+			}
+		}
+		sp := s.startSpan(parent, name, spanServer)
+		sp.setAttr("http.request.method", r.Method)
+		if route != "" {
+			sp.setAttr("http.route", route)
+		}
+		sp.setAttr("url.path", r.URL.Path)
+		sp.setAttr("client.address", r.RemoteAddr)
+		if id := r.Header.Get(requestIDHeader); id != "" {
+			sp.setAttr("gophurls.request_id", id)
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(withSpanContext(r.Context(), sp.context(parent))))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		sp.setAttr("http.response.status_code", rec.status)
+		var err error
+		if rec.status >= 500 {
+			err = fmt.Errorf("HTTP status %d", rec.status)
+		}
+		sp.finish(err)
+	})
+}
+
+// spanExporter exports finished spans.
+type spanExporter interface {
+	export([]*span) error
+}
+
+// maxQueuedSpans is the maximum number of finished spans waiting to be
+// exported. More are dropped.
+const maxQueuedSpans = 2048
+
+// spanQueue batches finished spans and exports them in the background.
+type spanQueue struct {
+	exporter spanExporter
+	queue    chan *span
+	flushc   chan chan struct{}
+}
+
+// spans is where finished spans go, or nil if tracing is disabled. Like
+// OpenTelemetry's global tracer provider, it is shared by all Servers in
+// the process.
+var spans *spanQueue
+
+// StartTracing starts exporting the spans of all Servers to exporter: "otlp"
+// (to the OTLP/HTTP traces endpoint at the URL otlpEndpoint), "stdout", or
+// "" (nowhere). It must be called before any Servers are started.
+func StartTracing(exporter, otlpEndpoint string) error {
+	switch exporter {
+	case "":
+		return nil
+	case "stdout":
+		spans = newSpanQueue(&jsonExporter{w: os.Stdout})
+	case "otlp":
+		spans = newSpanQueue(&otlpExporter{endpoint: otlpEndpoint, client: &http.Client{Timeout: 10 * time.Second}})
+	default:
+		return fmt.Errorf("bad trace exporter %q (want otlp or stdout)", exporter)
+	}
+	return nil
+}
+
+func newSpanQueue(e spanExporter) *spanQueue {
+	q := &spanQueue{exporter: e, queue: make(chan *span, maxQueuedSpans), flushc: make(chan chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *spanQueue) add(s *span) {
+	select {
+	case q.queue <- s:
+	default:
+		slog.Warn("Span queue is full; dropping span", "span", s.name)
+	}
+}
+
+// flush exports all queued spans, and waits until that's done.
+func (q *spanQueue) flush() {
+	if q == nil {
+		return
+	}
+	done := make(chan struct{})
+	q.flushc <- done
+	<-done
+}
+
+// run exports queued spans every second (or sooner, when enough are
+// queued).
+func (q *spanQueue) run() {
+	const maxBatch = 512
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var batch []*span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := q.exporter.export(batch); err != nil {
+			slog.Error("Error exporting spans", "spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-q.queue:
+			if batch = append(batch, s); len(batch) >= maxBatch {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-q.flushc:
+		drain:
+			for {
+				select {
+				case s := <-q.queue:
+					batch = append(batch, s)
+				default:
+					break drain
+				}
+			}
+			export()
+			close(done)
+		}
+	}
+}
+
+// jsonExporter writes spans to w as OTLP JSON spans, one per line.
+type jsonExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *jsonExporter) export(ss []*span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range ss {
+		if err := enc.Encode(s.otlp()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otlpExporter sends spans to an OTLP/HTTP endpoint.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpExporter) export(ss []*span) error {
+	// Each server (of which there may be many in one process) is a separate
+	// resource.
+	var req otlpTraces
+	byNode := make(map[string]int) // index in req.ResourceSpans
+	for _, s := range ss {
+		i, present := byNode[s.node]
+		if !present {
+			i = len(req.ResourceSpans)
+			byNode[s.node] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: []otlpKeyValue{
+					otlpAttr("service.name", "gophurls"),
+					otlpAttr("service.instance.id", s.node),
+				}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gophurls"}}},
+			})
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, s.otlp())
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding of spans. IDs are hex strings and 64-bit integers
+// are decimal strings.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func otlpAttr(key string, value any) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// otlp returns the OTLP JSON encoding of s.
+func (s *span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent.valid() {
+		o.ParentSpanID = hex.EncodeToString(s.parent.spanID[:])
+	}
+	for _, key := range sortedKeys(s.attrs) {
+		o.Attributes = append(o.Attributes, otlpAttr(key, s.attrs[key]))
+	}
+	for _, l := range s.links {
+		o.Links = append(o.Links, otlpLink{TraceID: hex.EncodeToString(l.traceID[:]), SpanID: hex.EncodeToString(l.spanID[:])})
+	}
+	if s.err != "" {
+		o.Status = otlpStatus{Code: 2, Message: s.err}
+	}
+	return o
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(tp)
+	if !ok || !sc.sampled {
+		t.Fatalf("got %+v, %v, want valid sampled span context", sc, ok)
+	}
+	if got := sc.traceparent(); got != tp {
+		t.Errorf("got traceparent %q, want %q", got, tp)
+	}
+	for _, bad := range []string{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Errorf("parseTraceparent(%q): got ok, want invalid", bad)
+		}
+	}
+}
+
+// TestTracing tests that spans for POST /links and its broadcast continue
+// the trace in the request's traceparent header, and that the trace context
+// is sent on to peers.
+func TestTracing(t *testing.T) {
+	s := newTestServer(t)
+	var buf bytes.Buffer
+	spans = newSpanQueue(&jsonExporter{w: &buf})
+	defer func() { spans = nil }()
+
+	peerTraceparents := make(chan string, 1)
+	fakePeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerTraceparents <- r.Header.Get("traceparent")
+	}))
+	defer fakePeer.Close()
+	fakePeerURL, _ := url.Parse(fakePeer.URL)
+	s.AddPeers(fakePeerURL.Host)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/links", strings.NewReader(`{"URL":"http://trace.example.com/","Title":"Traced"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.Handler().ServeHTTP(resp, req)
+	testStatusCode(t, "adding a link with a traceparent", resp.Code, http.StatusOK)
+
+	select {
+	case tp := <-peerTraceparents:
+		if sc, ok := parseTraceparent(tp); !ok || !strings.HasPrefix(tp, "00-"+traceID) || sc.spanID == [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+			t.Errorf("got traceparent %q on broadcast, want a child span in trace %s", tp, traceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fake peer did not receive broadcasted link")
+	}
+
+	// Wait for the broadcast span to end, then check the exported spans.
+	names := make(map[string]otlpSpan)
+	for deadline := time.Now().Add(time.Second); len(names) < 2 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		spans.flush()
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for {
+			var s otlpSpan
+			if err := dec.Decode(&s); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			names[s.Name] = s
+		}
+	}
+	for _, name := range []string{"POST /links", "broadcast"} {
+		s, ok := names[name]
+		if !ok {
+			t.Errorf("no %q span exported (got %v)", name, names)
+			continue
+		}
+		if s.TraceID != traceID {
+			t.Errorf("%q span: got trace ID %s, want %s", name, s.TraceID, traceID)
+		}
+	}
+	if names["broadcast"].ParentSpanID != names["POST /links"].SpanID {
+		t.Errorf("got broadcast span parent %s, want POST /links span %s", names["broadcast"].ParentSpanID, names["POST /links"].SpanID)
+	}
+}
+
+// TestTracing_SpanName tests that request spans are named by the pattern
+// that matched, not the path (which is in the url.path attribute).
+func TestTracing_SpanName(t *testing.T) {
+	s := newTestServer(t)
+	var buf bytes.Buffer
+	spans = newSpanQueue(&jsonExporter{w: &buf})
+	defer func() { spans = nil }()
+
+	doRequest(s, "GET", "/links/0123456789abcdef", "")
+	spans.flush()
+	var sp otlpSpan
+	if err := json.NewDecoder(&buf).Decode(&sp); err != nil {
+		t.Fatal(err)
+	}
+	if want := "GET /links/"; sp.Name != want {
+		t.Errorf("got span name %q, want %q", sp.Name, want)
+	}
+	var path string
+	for _, kv := range sp.Attributes {
+		if kv.Key == "url.path" && kv.Value.StringValue != nil {
+			path = *kv.Value.StringValue
+		}
+	}
+	if want := "/links/0123456789abcdef"; path != want {
+		t.Errorf("got url.path %q, want %q", path, want)
+	}
+}
+
+// TestOTLPExporter tests that spans are sent to an OTLP/HTTP endpoint.
+func TestOTLPExporter(t *testing.T) {
+	s := newTestServer(t)
+	received := make(chan otlpTraces, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpTraces
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: bad JSON: %s", err)
+		}
+		received <- req
+	}))
+	defer collector.Close()
+
+	spans = newSpanQueue(&otlpExporter{endpoint: collector.URL, client: http.DefaultClient})
+	defer func() { spans = nil }()
+	s.startSpan(spanContext{}, "test", spanInternal).finish(nil)
+	spans.flush()
+
+	req := <-received
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("got %+v, want 1 span", req)
+	}
+	if s := req.ResourceSpans[0].ScopeSpans[0].Spans[0]; s.Name != "test" || len(s.TraceID) != 32 {
+		t.Errorf("got span %+v, want span named test with a trace ID", s)
+	}
+}
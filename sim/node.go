@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/gophurls/server"
+)
+
+// Node is a simulated node: a server, reachable by its peers at Host over
+// the cluster's network.
+type Node struct {
+	ID     int
+	Host   string
+	Server *server.Server
+
+	c    *Cluster
+	skew time.Duration
+}
+
+// Add adds a link, as if a user had POSTed it to /links. If title is "",
+// the server fetches it (from Config.Web).
+func (n *Node) Add(url, title string) {
+	b, _ := json.Marshal(struct {
+		URL   string
+		Title string `json:",omitempty"`
+	}{url, title})
+	n.do("POST", "/links", string(b))
+}
+
+// SetTitle edits a link's title, as if a user had PATCHed /links/{id}.
+func (n *Node) SetTitle(url, title string) {
+	b, _ := json.Marshal(struct{ Title string }{title})
+	n.do("PATCH", "/links/"+server.LinkID(url), string(b))
+}
+
+// Delete deletes a link, as if a user had DELETEd /links/{id}. It reports
+// whether the link was present.
+func (n *Node) Delete(url string) bool {
+	return n.do("DELETE", "/links/"+server.LinkID(url), "").Code == http.StatusOK
+}
+
+// Links returns the URLs of the node's links (listed by GET /links),
+// sorted.
+func (n *Node) Links() []string {
+	var urls []string
+	for url := range n.list() {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// Title returns the title of the node's copy of a link, or "" if the node
+// doesn't list it.
+func (n *Node) Title(url string) string {
+	return n.list()[url].Title
+}
+
+// listedLink is a link as listed by GET /links.
+type listedLink struct {
+	URL   string
+	Title string
+	Tags  []string
+}
+
+func (l listedLink) String() string {
+	return fmt.Sprintf("%q %v", l.Title, l.Tags)
+}
+
+// list returns the node's links, by URL, as listed by GET /links.
+func (n *Node) list() map[string]listedLink {
+	var ls []listedLink
+	if err := json.NewDecoder(n.do("GET", "/links", "").Body).Decode(&ls); err != nil {
+		panic(fmt.Sprintf("node%d: GET /links: %s", n.ID, err))
+	}
+	m := make(map[string]listedLink, len(ls))
+	for _, l := range ls {
+		m[l.URL] = l
+	}
+	return m
+}
+
+// do serves a request from a user with the node's server, and then lets the
+// servers settle (so that the requests they make as a result are sent).
+func (n *Node) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://"+n.Host+path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("content-type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	n.Server.Handler().ServeHTTP(rec, req)
+	n.c.settle()
+	return rec
+}
+
+// nodeClock is a node's clock: the cluster's virtual time, skewed.
+type nodeClock struct{ n *Node }
+
+func (c nodeClock) Now() time.Time                         { return c.n.c.Now().Add(c.n.skew) }
+func (c nodeClock) After(d time.Duration) <-chan time.Time { return c.n.c.after(d) }
@@ -0,0 +1,482 @@
+// Package sim simulates a cluster of GophURLs servers in one process, so
+// that how links spread between peers can be tested deterministically.
+//
+// Each simulated node is a real server.Server. The nodes' requests to each
+// other (and to the web, to fetch titles) travel over an in-memory network
+// with random (but seeded) latencies, drops and partitions, and time is
+// virtual: the servers' clocks (see server.Options.Clock) only advance as
+// the cluster runs its scheduled events, so nothing sleeps, and every run
+// with the same Config and operations makes the same requests in the same
+// order.
+//
+// After each event, a cluster waits for the servers' goroutines to settle
+// before running the next, with synctest.Wait, so clusters must be created,
+// run and closed inside a testing/synctest bubble:
+//
+//	synctest.Test(t, func(t *testing.T) {
+//		c := sim.New(sim.Config{Nodes: 3})
+//		defer c.Close()
+//		...
+//	})
+//
+// Streams between peers (server.Options.Stream) aren't simulated.
+package sim
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing/synctest"
+	"time"
+
+	"github.com/sourcegraph/gophurls/server"
+)
+
+// Config configures a simulated cluster.
+type Config struct {
+	Nodes int
+	Seed  uint64
+
+	// Peers lists the peers of each node (by index). If nil, every node is
+	// a peer of every other.
+	Peers [][]int
+
+	// MinLatency and MaxLatency bound the time requests and responses take
+	// to be delivered, which is chosen uniformly at random for each. They
+	// default to 1ms and 10ms.
+	MinLatency, MaxLatency time.Duration
+
+	// DropRate is the fraction (0 to 1) of requests and responses between
+	// nodes that are lost (and fail, as if the connection broke).
+	DropRate float64
+
+	// MaxClockSkew bounds how far each node's clock is from the virtual
+	// time (chosen at random for each node).
+	MaxClockSkew time.Duration
+
+	// Web serves the pages that nodes fetch titles from: requests to hosts
+	// other than the nodes' go to it (without drops or partitions). If nil,
+	// they fail.
+	Web http.Handler
+}
+
+// epoch is when simulations start.
+var epoch = time.Date(2014, time.April, 24, 0, 0, 0, 0, time.UTC)
+
+// Cluster is a simulated cluster of nodes.
+type Cluster struct {
+	Nodes []*Node
+
+	cfg   Config
+	rng   *rand.Rand
+	hosts map[string]*Node
+
+	// mu guards the virtual time and the scheduled events, which the
+	// servers' goroutines use through their clocks, and the requests that
+	// arrived from their transports since the cluster last settled.
+	mu      sync.Mutex
+	now     time.Time
+	events  eventQueue
+	seq     uint64
+	timers  map[time.Time]*event // the event firing the timers due at each time
+	arrived []*request
+
+	// group holds the partition group of each node; nodes in different
+	// groups can't reach each other.
+	group []int
+
+	// Stats counts requests between nodes by what happened to them.
+	// Dropped counts requests and responses that were lost or couldn't
+	// reach their node.
+	Stats struct {
+		Sent, Delivered, Dropped int
+	}
+}
+
+// New returns a simulated cluster with cfg.Nodes nodes, each with no links.
+func New(cfg Config) *Cluster {
+	if cfg.MinLatency == 0 && cfg.MaxLatency == 0 {
+		cfg.MinLatency, cfg.MaxLatency = time.Millisecond, 10*time.Millisecond
+	}
+	if cfg.MaxLatency < cfg.MinLatency {
+		cfg.MaxLatency = cfg.MinLatency
+	}
+	c := &Cluster{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		hosts:  make(map[string]*Node),
+		now:    epoch,
+		timers: make(map[time.Time]*event),
+		group:  make([]int, cfg.Nodes),
+	}
+	for i := range cfg.Nodes {
+		n := &Node{ID: i, Host: fmt.Sprintf("node%d:7000", i), c: c}
+		if cfg.MaxClockSkew > 0 {
+			n.skew = time.Duration(c.rng.Int64N(int64(2*cfg.MaxClockSkew))) - cfg.MaxClockSkew
+		}
+		n.Server = server.New(server.Options{
+			NodeID:    fmt.Sprintf("node%d", i),
+			Transport: transport{c, n},
+			Clock:     nodeClock{n},
+		})
+		c.Nodes = append(c.Nodes, n)
+		c.hosts[n.Host] = n
+	}
+	for i, n := range c.Nodes {
+		if cfg.Peers != nil {
+			for _, j := range cfg.Peers[i] {
+				n.Server.AddPeers(c.Nodes[j].Host)
+			}
+		} else {
+			for _, peer := range c.Nodes {
+				if peer != n {
+					n.Server.AddPeers(peer.Host)
+				}
+			}
+		}
+	}
+	return c
+}
+
+// Close shuts down the nodes' servers, and runs the cluster until their
+// goroutines are done, which must happen before the synctest bubble ends.
+func (c *Cluster) Close() {
+	// Don't wait for broadcasts to finish.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, n := range c.Nodes {
+		n.Server.Shutdown(ctx)
+	}
+	c.Run(time.Hour)
+}
+
+// Now returns the current virtual time.
+func (c *Cluster) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// after returns a channel that receives the virtual time once d has passed.
+// Timers due at the same time fire together, so that the order in which the
+// servers' goroutines set them doesn't matter.
+func (c *Cluster) after(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at := c.now.Add(max(d, 0))
+	e, present := c.timers[at]
+	if !present {
+		e = c.schedule(at, nil)
+		c.timers[at] = e
+	}
+	e.fire = append(e.fire, ch)
+	return ch
+}
+
+// schedule schedules f to run at the given virtual time. c.mu must be held.
+func (c *Cluster) schedule(at time.Time, f func()) *event {
+	c.seq++
+	e := &event{at: at, seq: c.seq, f: f}
+	heap.Push(&c.events, e)
+	return e
+}
+
+// afterLatency schedules f to run after a random latency.
+func (c *Cluster) afterLatency(f func()) {
+	latency := c.cfg.MinLatency
+	if d := c.cfg.MaxLatency - c.cfg.MinLatency; d > 0 {
+		latency += time.Duration(c.rng.Int64N(int64(d) + 1))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(c.now.Add(latency), f)
+}
+
+// settle waits until the servers' goroutines are blocked (on the network,
+// on their clocks, or for more work), and then sends the requests they
+// made meanwhile, in a deterministic order.
+func (c *Cluster) settle() {
+	synctest.Wait()
+	c.mu.Lock()
+	rs := c.arrived
+	c.arrived = nil
+	c.mu.Unlock()
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].less(rs[j]) })
+	for _, r := range rs {
+		c.send(r)
+	}
+}
+
+// step runs the next scheduled event (advancing virtual time to it), and
+// lets the servers settle.
+func (c *Cluster) step() {
+	c.mu.Lock()
+	e := heap.Pop(&c.events).(*event)
+	c.now = e.at
+	if c.timers[e.at] == e {
+		delete(c.timers, e.at)
+	}
+	c.mu.Unlock()
+	for _, ch := range e.fire {
+		ch <- e.at
+	}
+	if e.f != nil {
+		e.f()
+	}
+	c.settle()
+}
+
+// next returns when the next event is scheduled, if there is one.
+func (c *Cluster) next() (time.Time, bool) {
+	c.settle()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.events) == 0 {
+		return time.Time{}, false
+	}
+	return c.events[0].at, true
+}
+
+// Step runs the next scheduled event (advancing virtual time to it), and
+// reports whether there was one.
+func (c *Cluster) Step() bool {
+	if _, ok := c.next(); !ok {
+		return false
+	}
+	c.step()
+	return true
+}
+
+// Run runs events until none are left (that is, until every request has
+// been answered and the servers aren't waiting to retry any), or until
+// virtual time limit has passed. It reports whether the cluster became
+// idle.
+func (c *Cluster) Run(limit time.Duration) bool {
+	deadline := c.Now().Add(limit)
+	for {
+		at, ok := c.next()
+		if !ok {
+			return true
+		}
+		if at.After(deadline) {
+			c.mu.Lock()
+			c.now = deadline
+			c.mu.Unlock()
+			return false
+		}
+		c.step()
+	}
+}
+
+// RunFor runs events for d of virtual time.
+func (c *Cluster) RunFor(d time.Duration) {
+	deadline := c.Now().Add(d)
+	for {
+		if at, ok := c.next(); !ok || at.After(deadline) {
+			break
+		}
+		c.step()
+	}
+	c.mu.Lock()
+	c.now = deadline
+	c.mu.Unlock()
+}
+
+// Partition splits the nodes into groups that can't reach each other.
+// Nodes not in any of the given groups form another group. Requests and
+// responses already on their way between groups are lost.
+func (c *Cluster) Partition(groups ...[]int) {
+	for i := range c.group {
+		c.group[i] = 0
+	}
+	for g, nodes := range groups {
+		for _, i := range nodes {
+			c.group[i] = g + 1
+		}
+	}
+}
+
+// Heal ends any partition.
+func (c *Cluster) Heal() { c.Partition() }
+
+func (c *Cluster) reachable(from, to *Node) bool { return c.group[from.ID] == c.group[to.ID] }
+
+var (
+	errDropped     = errors.New("sim: connection lost")
+	errUnreachable = errors.New("sim: host unreachable")
+	errNoWeb       = errors.New("sim: no such host")
+)
+
+// request is an HTTP request made by a node's server.
+type request struct {
+	from *Node
+	req  *http.Request
+	body []byte
+	done chan roundTrip
+}
+
+type roundTrip struct {
+	resp *http.Response
+	err  error
+}
+
+// less orders requests that arrived while the cluster settled.
+func (r *request) less(o *request) bool {
+	if r.from.ID != o.from.ID {
+		return r.from.ID < o.from.ID
+	}
+	if a, b := r.req.URL.String(), o.req.URL.String(); a != b {
+		return a < b
+	}
+	return bytes.Compare(r.body, o.body) < 0
+}
+
+// send sends r over the network: after a random latency, it is served
+// unless it is dropped or a partition separates its nodes by then, and
+// then its response comes back the same way.
+func (c *Cluster) send(r *request) {
+	to, isNode := c.hosts[r.req.URL.Host]
+	if !isNode {
+		c.afterLatency(func() {
+			if c.cfg.Web == nil {
+				r.done <- roundTrip{err: errNoWeb}
+				return
+			}
+			resp := serve(c.cfg.Web, r)
+			c.afterLatency(func() { r.done <- roundTrip{resp: resp} })
+		})
+		return
+	}
+
+	c.Stats.Sent++
+	lost := func() bool { return c.rng.Float64() < c.cfg.DropRate }
+	requestLost, responseLost := lost(), lost()
+	c.afterLatency(func() {
+		if requestLost || !c.reachable(r.from, to) {
+			c.Stats.Dropped++
+			r.done <- roundTrip{err: errUnreachable}
+			return
+		}
+		c.Stats.Delivered++
+		resp := serve(to.Server.Handler(), r)
+		c.afterLatency(func() {
+			if responseLost || !c.reachable(r.from, to) {
+				c.Stats.Dropped++
+				r.done <- roundTrip{err: errDropped}
+				return
+			}
+			r.done <- roundTrip{resp: resp}
+		})
+	})
+}
+
+// serve serves r with h, and returns the response.
+func serve(h http.Handler, r *request) *http.Response {
+	req := httptest.NewRequest(r.req.Method, r.req.URL.String(), bytes.NewReader(r.body))
+	req.Header = r.req.Header.Clone()
+	req.RemoteAddr = r.from.Host
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+// transport carries a node's requests over the simulated network.
+type transport struct {
+	c    *Cluster
+	from *Node
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := &request{from: t.from, req: req, body: body, done: make(chan roundTrip, 1)}
+	t.c.mu.Lock()
+	t.c.arrived = append(t.c.arrived, r)
+	t.c.mu.Unlock()
+	select {
+	case rt := <-r.done:
+		if rt.resp != nil {
+			rt.resp.Request = req
+		}
+		return rt.resp, rt.err
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// Converged reports whether every node lists the same links.
+func (c *Cluster) Converged() bool { return len(c.Divergence()) == 0 }
+
+// Divergence describes the links that nodes list differently (with GET
+// /links), comparing each node's with node0's.
+func (c *Cluster) Divergence() []string {
+	lists := make([]map[string]listedLink, len(c.Nodes))
+	urls := make(map[string]struct{})
+	for i, n := range c.Nodes {
+		lists[i] = n.list()
+		for url := range lists[i] {
+			urls[url] = struct{}{}
+		}
+	}
+	describe := func(ls map[string]listedLink, url string) string {
+		if l, present := ls[url]; present {
+			return l.String()
+		}
+		return "none"
+	}
+	var diffs []string
+	for url := range urls {
+		want := describe(lists[0], url)
+		for i := 1; i < len(c.Nodes); i++ {
+			if got := describe(lists[i], url); got != want {
+				diffs = append(diffs, fmt.Sprintf("%s: node0 has %s, node%d has %s", url, want, i, got))
+			}
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// event is something scheduled to happen at a virtual time: f is run, and
+// fire receives the time. Events at the same time happen in the order they
+// were scheduled.
+type event struct {
+	at   time.Time
+	seq  uint64
+	f    func()
+	fire []chan time.Time
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x any)   { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,156 @@
+package sim
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// runOps runs a pseudo-random sequence of adds, edits and deletes on random
+// nodes (chosen with the cluster's seeded generator), letting some virtual
+// time pass between them.
+func runOps(c *Cluster, numOps int) {
+	urls := []string{"http://a.com", "http://b.com", "http://c.com", "http://d.com"}
+	for op := 0; op < numOps; op++ {
+		n := c.Nodes[c.rng.IntN(len(c.Nodes))]
+		url := urls[c.rng.IntN(len(urls))]
+		switch c.rng.IntN(4) {
+		case 0, 1:
+			n.Add(url, "title")
+		case 2:
+			n.SetTitle(url, fmt.Sprintf("title %d", op))
+		case 3:
+			n.Delete(url)
+		}
+		c.RunFor(time.Duration(c.rng.IntN(5)) * time.Millisecond)
+	}
+}
+
+// TestConverge tests that servers converge, in various topologies, however
+// their requests are scheduled.
+func TestConverge(t *testing.T) {
+	ring := func(n int) [][]int {
+		peers := make([][]int, n)
+		for i := range peers {
+			peers[i] = []int{(i + 1) % n, (i + n - 1) % n}
+		}
+		return peers
+	}
+	star := func(n int) [][]int {
+		peers := make([][]int, n)
+		for i := 1; i < n; i++ {
+			peers[0] = append(peers[0], i)
+			peers[i] = []int{0}
+		}
+		return peers
+	}
+	topologies := map[string][][]int{"mesh": nil, "ring": ring(5), "star": star(5)}
+	for name, peers := range topologies {
+		for seed := uint64(1); seed <= 10; seed++ {
+			synctest.Test(t, func(t *testing.T) {
+				c := New(Config{Nodes: 5, Seed: seed, Peers: peers, MaxClockSkew: 50 * time.Millisecond})
+				defer c.Close()
+				runOps(c, 30)
+				if !c.Run(time.Minute) {
+					t.Fatalf("%s, seed %d: cluster did not become idle", name, seed)
+				}
+				if diffs := c.Divergence(); len(diffs) > 0 {
+					t.Errorf("%s, seed %d: nodes diverged:\n%v", name, seed, diffs)
+				}
+			})
+		}
+	}
+}
+
+// TestDeterministic tests that runs with the same seed make the same
+// requests and end in the same state.
+func TestDeterministic(t *testing.T) {
+	run := func() (titles []string, stats any) {
+		synctest.Test(t, func(t *testing.T) {
+			c := New(Config{Nodes: 4, Seed: 42, DropRate: 0.2, MaxClockSkew: time.Second})
+			defer c.Close()
+			runOps(c, 50)
+			c.Run(time.Minute)
+			for _, n := range c.Nodes {
+				for _, url := range n.Links() {
+					titles = append(titles, fmt.Sprintf("node%d %s %q", n.ID, url, n.Title(url)))
+				}
+			}
+			stats = c.Stats
+		})
+		return titles, stats
+	}
+	titles1, stats1 := run()
+	titles2, stats2 := run()
+	if !reflect.DeepEqual(titles1, titles2) || stats1 != stats2 {
+		t.Errorf("runs with the same seed differ:\n%v %+v\n%v %+v", titles1, stats1, titles2, stats2)
+	}
+}
+
+// TestDrops tests that servers converge even though many of their requests
+// (or the responses to them) are lost, by resending the links they failed to
+// send.
+func TestDrops(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		c := New(Config{Nodes: 4, Seed: 1, DropRate: 0.5})
+		defer c.Close()
+		for i := 0; i < 20; i++ {
+			c.Nodes[0].Add(fmt.Sprintf("http://example.com/%d", i), "title")
+		}
+		if !c.Run(time.Hour) {
+			t.Fatal("cluster did not become idle")
+		}
+		if diffs := c.Divergence(); len(diffs) > 0 {
+			t.Errorf("nodes diverged:\n%v", diffs)
+		}
+		if got := len(c.Nodes[3].Links()); got != 20 {
+			t.Errorf("got %d links on node3, want 20", got)
+		}
+		if c.Stats.Dropped == 0 {
+			t.Error("no requests were dropped")
+		}
+	})
+}
+
+// TestPartition tests that servers converge after a partition heals, and
+// that a link deleted on one side of the partition stays deleted even though
+// it was edited on the other side.
+func TestPartition(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		c := New(Config{Nodes: 4, Seed: 1})
+		defer c.Close()
+		const url = "http://example.com"
+		c.Nodes[0].Add(url, "Example")
+		c.Run(time.Minute)
+
+		c.Partition([]int{0, 1}, []int{2, 3})
+		c.Nodes[0].Delete(url)
+		c.Nodes[2].SetTitle(url, "Edited")
+		c.Nodes[3].Add("http://example.com/other", "Other")
+		c.RunFor(10 * time.Second)
+		if c.Converged() {
+			t.Fatal("nodes converged across a partition")
+		}
+		if got := c.Nodes[1].Links(); len(got) != 0 {
+			t.Errorf("got links %v on node1 during the partition, want none", got)
+		}
+		if got, want := c.Nodes[3].Title(url), "Edited"; got != want {
+			t.Errorf("got title %q on node3 during the partition, want %q", got, want)
+		}
+
+		c.Heal()
+		if !c.Run(time.Hour) {
+			t.Fatal("cluster did not become idle after the partition healed")
+		}
+		if diffs := c.Divergence(); len(diffs) > 0 {
+			t.Errorf("nodes diverged after the partition healed:\n%v", diffs)
+		}
+		for _, n := range c.Nodes {
+			if got, want := n.Links(), []string{"http://example.com/other"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("node%d: got links %v, want %v", n.ID, got, want)
+			}
+		}
+	})
+}